@@ -0,0 +1,504 @@
+package generate
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/cloudflare/cfssl/log"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BindingResult reports what reconciling a binding did.
+type BindingResult string
+
+const (
+	BindingCreated   BindingResult = "created"
+	BindingUpdated   BindingResult = "updated"
+	BindingUnchanged BindingResult = "unchanged"
+)
+
+// SubjectAnnotation records the subject a binding is generated for, so that a
+// binding belonging to another subject is not silently taken over.
+const SubjectAnnotation = "gen-kubecfg/subject"
+
+// DefaultBindingNameTemplate gives subject kind and service account namespace
+// their own place in the name, the hash suffix keeps names unique even if the
+// parts contain '-'.
+const DefaultBindingNameTemplate = "{{.Kind}}-{{if .Namespace}}{{.Namespace}}-{{end}}{{.Username}}-{{.Role}}-{{.Hash}}"
+
+// BindingNameData is the data of the binding name template.
+type BindingNameData struct {
+	// Kind is "user" or "sa"
+	Kind      string
+	Namespace string
+	Username  string
+	RoleKind  string
+	Role      string
+	Hash      string
+}
+
+// BindingNamer names the bindings of a subject.
+type BindingNamer struct {
+	tmpl *template.Template
+}
+
+func NewBindingNamer(text string) (*BindingNamer, error) {
+	if text == "" {
+		text = DefaultBindingNameTemplate
+	}
+
+	tmpl, err := template.New("binding-name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse binding name template err: %w", err)
+	}
+
+	return &BindingNamer{tmpl: tmpl}, nil
+}
+
+func (n *BindingNamer) Name(subject rbacv1.Subject, roleKind, role string) (string, error) {
+	data := BindingNameData{
+		Kind:      "user",
+		Namespace: subject.Namespace,
+		Username:  subject.Name,
+		RoleKind:  roleKind,
+		Role:      role,
+	}
+	if subject.Kind == rbacv1.ServiceAccountKind {
+		data.Kind = "sa"
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join([]string{subject.Kind, subject.Namespace, subject.Name, roleKind, role}, "/")))
+	data.Hash = hex.EncodeToString(sum[:])[:8]
+
+	var buf bytes.Buffer
+	if err := n.tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("execute binding name template err: %w", err)
+	}
+
+	// '/' and '%' are not allowed in the name of rbac objects
+	name := strings.NewReplacer("/", "-", "%", "-").Replace(buf.String())
+	if name == "" || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid binding name %q", name)
+	}
+
+	return name, nil
+}
+
+func subjectString(s rbacv1.Subject) string {
+	if s.Namespace == "" {
+		return fmt.Sprintf("%s/%s", s.Kind, s.Name)
+	}
+	return fmt.Sprintf("%s/%s/%s", s.Kind, s.Namespace, s.Name)
+}
+
+// checkOwner refuses to change a binding generated for another subject unless
+// force is set. A binding without the annotation, like the <user>-<role>
+// bindings of older versions, belongs to another subject if it has any other
+// subject.
+func checkOwner(kind, name string, annotations map[string]string, subjects []rbacv1.Subject, subject rbacv1.Subject, force bool) error {
+	if force {
+		return nil
+	}
+
+	owner, ok := annotations[SubjectAnnotation]
+	if !ok {
+		for _, s := range subjects {
+			if !hasSubject([]rbacv1.Subject{subject}, s) {
+				return fmt.Errorf("%s %s is not generated for %s and binds %s, use -force to add the subject anyway",
+					kind, name, subjectString(subject), subjectString(s))
+			}
+		}
+		return nil
+	}
+
+	if owner == subjectString(subject) {
+		return nil
+	}
+	return fmt.Errorf("%s %s belongs to %s instead of %s, use -force to add the subject anyway",
+		kind, name, owner, subjectString(subject))
+}
+
+func NewSubject(roleBindingType, username, saNameSpace string) rbacv1.Subject {
+	if roleBindingType == "User" {
+		return rbacv1.Subject{
+			APIGroup: rbacv1.GroupName,
+			Kind:     rbacv1.UserKind,
+			Name:     username,
+		}
+	}
+
+	return rbacv1.Subject{
+		Kind:      rbacv1.ServiceAccountKind,
+		Name:      username,
+		Namespace: saNameSpace,
+	}
+}
+
+// subject is the identity the kubeconfig of p authenticates as.
+func (p Params) subject() rbacv1.Subject {
+	if p.Type == TokenType {
+		return NewSubject("ServiceAccount", p.Username, p.ServiceAccountNamespace)
+	}
+	return NewSubject("User", p.Username, "")
+}
+
+func hasSubject(subjects []rbacv1.Subject, s rbacv1.Subject) bool {
+	for _, item := range subjects {
+		if item.Kind == s.Kind && item.Name == s.Name && item.Namespace == s.Namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// dropSubjects warns about the subjects other than subject which a forced
+// replacement of a binding removes.
+func dropSubjects(kind, name string, subjects []rbacv1.Subject, subject rbacv1.Subject) {
+	for _, s := range subjects {
+		if !hasSubject([]rbacv1.Subject{subject}, s) {
+			log.Warningf("%s %s is replaced for %s, it no longer binds %s", kind, name, subjectString(subject), subjectString(s))
+		}
+	}
+}
+
+// ownerAnnotations returns a copy of annotations owned by subject.
+func ownerAnnotations(annotations map[string]string, subject rbacv1.Subject) map[string]string {
+	res := make(map[string]string, len(annotations)+1)
+	for k, v := range annotations {
+		res[k] = v
+	}
+	res[SubjectAnnotation] = subjectString(subject)
+	return res
+}
+
+func roleRefMatch(a, b rbacv1.RoleRef) bool {
+	return a.Kind == b.Kind && a.Name == b.Name
+}
+
+func roleRefMismatchErr(kind, name string, got, want rbacv1.RoleRef) error {
+	return fmt.Errorf("%s %s refers to %s %s instead of %s %s, use -force to replace it",
+		kind, name, got.Kind, got.Name, want.Kind, want.Name)
+}
+
+// reconcileRoleBinding makes sure the role binding exists and contains subject,
+// other subjects, labels and annotations of an existing binding are kept. As the
+// roleRef of a binding is immutable, a binding referring to another role is only
+// replaced when force is set, the replacement only binds subject, so the other
+// subjects do not get the new role.
+func (kt *Client) reconcileRoleBinding(name, namespace string, subject rbacv1.Subject, roleRef rbacv1.RoleRef, force bool) (BindingResult, error) {
+	rbs := kt.client.RbacV1().RoleBindings(namespace)
+
+	rb, err := rbs.Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", err
+	}
+
+	if apierrors.IsNotFound(err) {
+		rb = &rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   namespace,
+				Annotations: map[string]string{SubjectAnnotation: subjectString(subject)},
+			},
+			Subjects: []rbacv1.Subject{subject},
+			RoleRef:  roleRef,
+		}
+		if _, err := rbs.Create(context.TODO(), rb, metav1.CreateOptions{}); err != nil {
+			return "", err
+		}
+		return BindingCreated, nil
+	}
+
+	if err := checkOwner("role binding", name, rb.Annotations, rb.Subjects, subject, force); err != nil {
+		return "", err
+	}
+
+	if !roleRefMatch(rb.RoleRef, roleRef) {
+		if !force {
+			return "", roleRefMismatchErr("role binding", name, rb.RoleRef, roleRef)
+		}
+
+		if err := rbs.Delete(context.TODO(), name, metav1.DeleteOptions{}); err != nil {
+			return "", err
+		}
+
+		dropSubjects("role binding", name, rb.Subjects, subject)
+		rb = &rbacv1.RoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   namespace,
+				Labels:      rb.Labels,
+				Annotations: ownerAnnotations(rb.Annotations, subject),
+			},
+			Subjects: []rbacv1.Subject{subject},
+			RoleRef:  roleRef,
+		}
+		if _, err := rbs.Create(context.TODO(), rb, metav1.CreateOptions{}); err != nil {
+			return "", err
+		}
+		return BindingUpdated, nil
+	}
+
+	if hasSubject(rb.Subjects, subject) {
+		return BindingUnchanged, nil
+	}
+
+	rb.Subjects = append(rb.Subjects, subject)
+	if _, err := rbs.Update(context.TODO(), rb, metav1.UpdateOptions{}); err != nil {
+		return "", err
+	}
+
+	return BindingUpdated, nil
+}
+
+// reconcileClusterRoleBinding is the cluster scoped version of reconcileRoleBinding.
+func (kt *Client) reconcileClusterRoleBinding(name string, subject rbacv1.Subject, roleRef rbacv1.RoleRef, force bool) (BindingResult, error) {
+	crbs := kt.client.RbacV1().ClusterRoleBindings()
+
+	crb, err := crbs.Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", err
+	}
+
+	if apierrors.IsNotFound(err) {
+		crb = &rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Annotations: map[string]string{SubjectAnnotation: subjectString(subject)},
+			},
+			Subjects: []rbacv1.Subject{subject},
+			RoleRef:  roleRef,
+		}
+		if _, err := crbs.Create(context.TODO(), crb, metav1.CreateOptions{}); err != nil {
+			return "", err
+		}
+		return BindingCreated, nil
+	}
+
+	if err := checkOwner("cluster role binding", name, crb.Annotations, crb.Subjects, subject, force); err != nil {
+		return "", err
+	}
+
+	if !roleRefMatch(crb.RoleRef, roleRef) {
+		if !force {
+			return "", roleRefMismatchErr("cluster role binding", name, crb.RoleRef, roleRef)
+		}
+
+		if err := crbs.Delete(context.TODO(), name, metav1.DeleteOptions{}); err != nil {
+			return "", err
+		}
+
+		dropSubjects("cluster role binding", name, crb.Subjects, subject)
+		crb = &rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Labels:      crb.Labels,
+				Annotations: ownerAnnotations(crb.Annotations, subject),
+			},
+			Subjects: []rbacv1.Subject{subject},
+			RoleRef:  roleRef,
+		}
+		if _, err := crbs.Create(context.TODO(), crb, metav1.CreateOptions{}); err != nil {
+			return "", err
+		}
+		return BindingUpdated, nil
+	}
+
+	if hasSubject(crb.Subjects, subject) {
+		return BindingUnchanged, nil
+	}
+
+	crb.Subjects = append(crb.Subjects, subject)
+	if _, err := crbs.Update(context.TODO(), crb, metav1.UpdateOptions{}); err != nil {
+		return "", err
+	}
+
+	return BindingUpdated, nil
+}
+
+// plannedBinding is a binding GenerateBinding reconciles, Namespace is empty
+// for a cluster role binding.
+type plannedBinding struct {
+	Namespace string
+	Name      string
+	RoleRef   rbacv1.RoleRef
+}
+
+func (b plannedBinding) String() string {
+	if b.Namespace == "" {
+		return "cluster role binding " + b.Name
+	}
+	return fmt.Sprintf("role binding %s in %s namespace", b.Name, b.Namespace)
+}
+
+// planBindings returns the bindings of the roles chosen in p for subject. A
+// role missing in a namespace is skipped, except for the custom role which
+// ApplyCustomRole creates.
+func (kt *Client) planBindings(p Params, subject rbacv1.Subject) ([]plannedBinding, error) {
+	namer, err := NewBindingNamer(p.BindingNameTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []plannedBinding
+	add := func(namespace, kind, role string) error {
+		name, err := namer.Name(subject, kind, role)
+		if err != nil {
+			return err
+		}
+		res = append(res, plannedBinding{
+			Namespace: namespace,
+			Name:      name,
+			RoleRef:   rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: kind, Name: role},
+		})
+		return nil
+	}
+
+	namespaces := p.NamespaceSlice()
+	if len(namespaces) == 0 {
+		for _, cr := range p.ClusterRoles {
+			if err := add("", "ClusterRole", cr); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+	}
+
+	custom := ""
+	if len(p.CustomRules) > 0 {
+		custom = CustomRoleName(subject)
+	}
+	for _, ns := range namespaces {
+		for _, cr := range p.ClusterRoles {
+			if err := add(ns, "ClusterRole", cr); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, r := range p.Roles {
+			if r != custom {
+				_, err := kt.client.RbacV1().Roles(ns).Get(context.TODO(), r, metav1.GetOptions{})
+				if apierrors.IsNotFound(err) {
+					log.Warningf("not found role %s in %s namespace, skip binding it", r, ns)
+					continue
+				}
+				if err != nil {
+					return nil, fmt.Errorf("get role %s in %s namespace err: %w", r, ns, err)
+				}
+			}
+			if err := add(ns, "Role", r); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return res, nil
+}
+
+// CheckBindings checks every binding of p against the existing one, like
+// GenerateBinding would, without changing anything: it must belong to the
+// subject and refer to the same role unless p.Force is set. It runs before
+// any credential is issued, the errors of all the bindings are returned.
+func (kt *Client) CheckBindings(p Params) error {
+	subject := p.subject()
+	// the custom role is created and bound after the credentials are issued
+	if len(p.CustomRules) > 0 {
+		name := CustomRoleName(subject)
+		if len(p.NamespaceSlice()) == 0 {
+			p.ClusterRoles = append(append([]string{}, p.ClusterRoles...), name)
+		} else {
+			p.Roles = append(append([]string{}, p.Roles...), name)
+		}
+	}
+
+	bindings, err := kt.planBindings(p, subject)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, b := range bindings {
+		if err := kt.checkBinding(b, subject, p.Force); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("bindings can not be generated:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+func (kt *Client) checkBinding(b plannedBinding, subject rbacv1.Subject, force bool) error {
+	var (
+		annotations map[string]string
+		subjects    []rbacv1.Subject
+		roleRef     rbacv1.RoleRef
+		kind        string
+	)
+	if b.Namespace == "" {
+		kind = "cluster role binding"
+		crb, err := kt.client.RbacV1().ClusterRoleBindings().Get(context.TODO(), b.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		annotations, subjects, roleRef = crb.Annotations, crb.Subjects, crb.RoleRef
+	} else {
+		kind = "role binding"
+		rb, err := kt.client.RbacV1().RoleBindings(b.Namespace).Get(context.TODO(), b.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		annotations, subjects, roleRef = rb.Annotations, rb.Subjects, rb.RoleRef
+	}
+
+	if err := checkOwner(kind, b.Name, annotations, subjects, subject, force); err != nil {
+		return err
+	}
+	if !roleRefMatch(roleRef, b.RoleRef) && !force {
+		return roleRefMismatchErr(kind, b.Name, roleRef, b.RoleRef)
+	}
+	return nil
+}
+
+// GenerateBinding binds the cluster roles (and roles) chosen in p to the user,
+// roleBindingType is either "User" or "ServiceAccount". A failed binding does
+// not stop the others, the errors of all of them are returned.
+func (kt *Client) GenerateBinding(roleBindingType string, p Params) error {
+	subject := NewSubject(roleBindingType, p.Username, p.ServiceAccountNamespace)
+
+	bindings, err := kt.planBindings(p, subject)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, b := range bindings {
+		var res BindingResult
+		if b.Namespace == "" {
+			res, err = kt.reconcileClusterRoleBinding(b.Name, subject, b.RoleRef, p.Force)
+		} else {
+			res, err = kt.reconcileRoleBinding(b.Name, b.Namespace, subject, b.RoleRef, p.Force)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("reconcile %s for %s err: %v", b, p.Username, err))
+			continue
+		}
+		log.Infof("%s %s", b, res)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
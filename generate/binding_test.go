@@ -0,0 +1,200 @@
+package generate
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_reconcileClusterRoleBinding(t *testing.T) {
+	existing := &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "team-view",
+			Labels: map[string]string{"owner": "team"},
+		},
+		Subjects: []rbacv1.Subject{NewSubject("User", "bob", "")},
+		RoleRef:  rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"},
+	}
+	c := NewClient(fake.NewSimpleClientset(existing))
+
+	alice := NewSubject("User", "alice", "")
+	view := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"}
+	edit := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "edit"}
+
+	cases := []struct {
+		name    string
+		binding string
+		roleRef rbacv1.RoleRef
+		force   bool
+		want    BindingResult
+		wantErr bool
+	}{
+		{name: "missing", binding: "alice-view", roleRef: view, want: BindingCreated},
+		{name: "already bound", binding: "alice-view", roleRef: view, want: BindingUnchanged},
+		{name: "foreign subjects", binding: "team-view", roleRef: view, wantErr: true},
+		{name: "add subject forced", binding: "team-view", roleRef: view, force: true, want: BindingUpdated},
+		{name: "roleRef mismatch", binding: "team-view", roleRef: edit, wantErr: true},
+		{name: "roleRef mismatch forced", binding: "team-view", roleRef: edit, force: true, want: BindingUpdated},
+	}
+
+	for _, tc := range cases {
+		got, err := c.reconcileClusterRoleBinding(tc.binding, alice, tc.roleRef, tc.force)
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("%s: want err %v but got %v", tc.name, tc.wantErr, err)
+		}
+		if got != tc.want {
+			t.Errorf("%s: want %q but got %q", tc.name, tc.want, got)
+		}
+	}
+
+	crb, err := c.client.RbacV1().ClusterRoleBindings().Get(context.TODO(), "team-view", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the forced replacement only binds alice, bob does not get edit
+	if len(crb.Subjects) != 1 || crb.Subjects[0] != alice || crb.Labels["owner"] != "team" || crb.RoleRef.Name != "edit" {
+		t.Errorf("want only the subject bound and labels kept but got %+v", crb)
+	}
+	if owner := crb.Annotations[SubjectAnnotation]; owner != "User/alice" {
+		t.Errorf("want the binding owned by User/alice but got %q", owner)
+	}
+}
+
+func Test_BindingNamer(t *testing.T) {
+	namer, err := NewBindingNamer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subjects := []rbacv1.Subject{
+		NewSubject("ServiceAccount", "deploy", "a"),
+		NewSubject("ServiceAccount", "deploy", "b"),
+		NewSubject("User", "deploy", ""),
+	}
+
+	seen := make(map[string]bool)
+	for _, s := range subjects {
+		for _, kind := range []string{"ClusterRole", "Role"} {
+			name, err := namer.Name(s, kind, "edit")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if seen[name] {
+				t.Errorf("duplicated binding name %s", name)
+			}
+			seen[name] = true
+		}
+	}
+
+	if _, err := NewBindingNamer("{{.Unknown"); err == nil {
+		t.Errorf("want err for invalid template but got nil")
+	}
+}
+
+func Test_reconcileRoleBindingOwner(t *testing.T) {
+	c := NewClient(fake.NewSimpleClientset())
+	view := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"}
+
+	if _, err := c.reconcileRoleBinding("deploy-view", "default", NewSubject("User", "deploy", ""), view, false); err != nil {
+		t.Fatal(err)
+	}
+
+	sa := NewSubject("ServiceAccount", "deploy", "ci")
+	if _, err := c.reconcileRoleBinding("deploy-view", "default", sa, view, false); err == nil {
+		t.Errorf("want err for binding of another subject but got nil")
+	}
+
+	res, err := c.reconcileRoleBinding("deploy-view", "default", sa, view, true)
+	if err != nil || res != BindingUpdated {
+		t.Errorf("want %q but got %q, err: %v", BindingUpdated, res, err)
+	}
+}
+
+func Test_CheckBindings(t *testing.T) {
+	alice := NewSubject("User", "alice", "")
+	namer, _ := NewBindingNamer("")
+	viewName, _ := namer.Name(alice, "ClusterRole", "view")
+	editName, _ := namer.Name(alice, "ClusterRole", "edit")
+
+	c := NewClient(fake.NewSimpleClientset(
+		&rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: viewName, Annotations: map[string]string{SubjectAnnotation: "User/bob"}},
+			Subjects:   []rbacv1.Subject{NewSubject("User", "bob", "")},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"},
+		},
+		&rbacv1.ClusterRoleBinding{
+			ObjectMeta: metav1.ObjectMeta{Name: editName, Annotations: map[string]string{SubjectAnnotation: "User/alice"}},
+			Subjects:   []rbacv1.Subject{alice},
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "admin"},
+		},
+	))
+
+	p := Params{Type: ClientCertType, Username: "alice", ClusterRoles: []string{"view", "edit"}}
+	err := c.CheckBindings(p)
+	if err == nil || !strings.Contains(err.Error(), viewName) || !strings.Contains(err.Error(), editName) {
+		t.Fatalf("want the errors of both bindings but got %v", err)
+	}
+
+	p.Force = true
+	if err := c.CheckBindings(p); err != nil {
+		t.Errorf("want no err with force but got %v", err)
+	}
+
+	// nothing is changed by the check
+	crb, err := c.client.RbacV1().ClusterRoleBindings().Get(context.TODO(), editName, metav1.GetOptions{})
+	if err != nil || crb.RoleRef.Name != "admin" {
+		t.Errorf("want the binding untouched but got %+v, err: %v", crb, err)
+	}
+
+	// the custom role does not exist yet but is planned
+	p = Params{Type: ClientCertType, Username: "alice", Namespaces: "dev", CustomRules: []rbacv1.PolicyRule{{Verbs: []string{"get"}}}}
+	bindings, err := c.planBindings(p, alice)
+	if err != nil || len(bindings) != 0 {
+		t.Errorf("want the custom role only planned by CheckBindings but got %v, err: %v", bindings, err)
+	}
+	if err := c.CheckBindings(p); err != nil {
+		t.Errorf("want no err for the custom role but got %v", err)
+	}
+}
+
+func Test_GenerateBindingRole(t *testing.T) {
+	reader := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: "reader", Namespace: "dev"},
+		Rules:      []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}},
+	}
+	c := NewClient(fake.NewSimpleClientset(reader))
+
+	if got := c.GetRoleNames([]string{"dev", "prod"}); len(got) != 1 || got[0] != "reader" {
+		t.Errorf("want only the role of dev namespace but got %v", got)
+	}
+
+	p := Params{Username: "alice", Namespaces: "dev,prod", ClusterRoles: []string{"view"}, Roles: []string{"reader"}}
+	if err := c.GenerateBinding("User", p); err != nil {
+		t.Fatal(err)
+	}
+
+	dev, err := c.client.RbacV1().RoleBindings("dev").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(map[string]string)
+	for _, rb := range dev.Items {
+		kinds[rb.RoleRef.Name] = rb.RoleRef.Kind
+	}
+	if len(kinds) != 2 || kinds["reader"] != "Role" || kinds["view"] != "ClusterRole" {
+		t.Errorf("want the role and the cluster role bound in dev namespace but got %v", kinds)
+	}
+
+	// the role is missing in prod, only the cluster role is bound there
+	prod, err := c.client.RbacV1().RoleBindings("prod").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prod.Items) != 1 || prod.Items[0].RoleRef.Kind != "ClusterRole" || prod.Items[0].RoleRef.Name != "view" {
+		t.Errorf("want only the cluster role bound in prod namespace but got %+v", prod.Items)
+	}
+}
@@ -0,0 +1,460 @@
+package generate
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/AlecAivazis/survey/v2/terminal"
+	"github.com/cloudflare/cfssl/log"
+	xproxy "golang.org/x/net/proxy"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+	kubecmd "k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// CASourceAuto tries the CA file if given, then the kubeconfig,
+	// kube-root-ca.crt and cluster-info sources in order and takes the first
+	// CA the serving certificate verifies against.
+	CASourceAuto        = "auto"
+	CASourceKubeconfig  = "kubeconfig"
+	CASourceRootCA      = "root-ca"
+	CASourceClusterInfo = "cluster-info"
+	CASourceFile        = "file"
+	// CASourceHandshake trusts the top certificate the server presents once
+	// it is confirmed, it is never chosen automatically.
+	CASourceHandshake = "handshake"
+
+	rootCAConfigMap = "kube-root-ca.crt"
+	rootCAKey       = "ca.crt"
+
+	caDialTimeout = 5 * time.Second
+)
+
+var autoCASources = []string{CASourceKubeconfig, CASourceRootCA, CASourceClusterInfo}
+
+// DiscoverCA returns the CA bundle of the API server cfg connects to, read
+// from source. The bundle is checked by a TLS handshake with the server, a
+// bundle the serving certificate does not verify against is an error.
+// The handshake CA is confirmed on console.
+func (kt *Client) DiscoverCA(source string, cfg *rest.Config, caFile string, console terminal.FileWriter) (string, error) {
+	if source != CASourceAuto {
+		ca, err := kt.readCA(source, cfg, caFile, console)
+		if err != nil {
+			return "", fmt.Errorf("read cluster CA from %s err: %w", source, err)
+		}
+		if err := VerifyCA(ca, cfg); err != nil {
+			if isUnreachable(err) {
+				return "", fmt.Errorf("verify cluster CA from %s err: %w", source, err)
+			}
+			return "", fmt.Errorf("cluster CA from %s does not verify the server: %w", source, err)
+		}
+		return ca, nil
+	}
+
+	sources := autoCASources
+	if caFile != "" {
+		sources = append([]string{CASourceFile}, sources...)
+	}
+
+	for _, s := range sources {
+		ca, err := kt.readCA(s, cfg, caFile, console)
+		if err != nil {
+			log.Debugf("read cluster CA from %s err: %v", s, err)
+			continue
+		}
+		if err := VerifyCA(ca, cfg); err != nil {
+			// no other source verifies against a server which is not reached
+			if isUnreachable(err) {
+				return "", fmt.Errorf("verify cluster CA from %s err: %w", s, err)
+			}
+			log.Warningf("cluster CA from %s does not verify the server: %v", s, err)
+			continue
+		}
+		log.Infof("use cluster CA from %s", s)
+		return ca, nil
+	}
+
+	return "", fmt.Errorf("not found a cluster CA verifying the server in %s, choose another source with -ca-source",
+		strings.Join(sources, ", "))
+}
+
+func (kt *Client) readCA(source string, cfg *rest.Config, caFile string, console terminal.FileWriter) (string, error) {
+	switch source {
+	case CASourceKubeconfig:
+		if len(cfg.TLSClientConfig.CAData) > 0 {
+			return string(cfg.TLSClientConfig.CAData), nil
+		}
+		if cfg.TLSClientConfig.CAFile != "" {
+			return readCAFile(cfg.TLSClientConfig.CAFile)
+		}
+		return "", fmt.Errorf("the kubeconfig has no certificate authority")
+	case CASourceRootCA:
+		cm, err := kt.client.CoreV1().ConfigMaps(metav1.NamespaceDefault).Get(context.TODO(), rootCAConfigMap, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		ca, ok := cm.Data[rootCAKey]
+		if !ok {
+			return "", fmt.Errorf("not found %s in config map %s", rootCAKey, rootCAConfigMap)
+		}
+		return ca, nil
+	case CASourceClusterInfo:
+		return kt.clusterInfoCA()
+	case CASourceFile:
+		if caFile == "" {
+			return "", fmt.Errorf("no CA file given")
+		}
+		return readCAFile(caFile)
+	case CASourceHandshake:
+		return HandshakeCA(cfg, console)
+	}
+	return "", fmt.Errorf("unknown CA source %q", source)
+}
+
+// clusterInfoCA reads the CA of the kubeconfig which kubeadm publishes in the
+// kube-public/cluster-info config map.
+func (kt *Client) clusterInfoCA() (string, error) {
+	cm, err := kt.client.CoreV1().ConfigMaps(metav1.NamespacePublic).Get(context.TODO(), "cluster-info", metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	cfg, err := kubecmd.Load([]byte(cm.Data["kubeconfig"]))
+	if err != nil {
+		return "", fmt.Errorf("load cluster-info kubeconfig err: %w", err)
+	}
+	for _, cluster := range cfg.Clusters {
+		if len(cluster.CertificateAuthorityData) > 0 {
+			return string(cluster.CertificateAuthorityData), nil
+		}
+	}
+	return "", fmt.Errorf("the cluster-info kubeconfig has no certificate authority")
+}
+
+func readCAFile(filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// HandshakeCA returns the top certificate of the chain presented by the
+// server. Nothing verifies it, so its fingerprint is shown and has to be
+// confirmed by the operator.
+func HandshakeCA(cfg *rest.Config, console terminal.FileWriter) (string, error) {
+	tlsConfig, err := configTLS(cfg)
+	if err != nil {
+		return "", err
+	}
+	tlsConfig.RootCAs = nil
+	tlsConfig.InsecureSkipVerify = true
+
+	conn, err := dialServer(cfg.Host, cfg.TLSClientConfig.ServerName, tlsConfig, configProxy(cfg))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", fmt.Errorf("the server presented no certificate")
+	}
+
+	top := certs[len(certs)-1]
+	log.Warningf("THE CLUSTER CA IS TAKEN FROM THE TLS HANDSHAKE WITHOUT VERIFICATION, compare its fingerprint out of band")
+	log.Warningf("subject: %s, issuer: %s, sha256 fingerprint: %s", top.Subject, top.Issuer, fingerprint(top))
+	if !top.IsCA {
+		log.Warningf("the certificate is not a CA, the server does not present the top of its chain")
+	}
+
+	ok, err := confirmHandshakeCA(top, console)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("the certificate presented by the server is not trusted")
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: top.Raw})), nil
+}
+
+// confirmHandshakeCA asks whether to trust the certificate of HandshakeCA.
+var confirmHandshakeCA = func(cert *x509.Certificate, console terminal.FileWriter) (bool, error) {
+	ok := false
+	prompt := &survey.Confirm{
+		Message: fmt.Sprintf("Trust '%s' with fingerprint %s as the cluster CA?", cert.Subject, fingerprint(cert)),
+		Default: false,
+	}
+	err := survey.AskOne(prompt, &ok, survey.WithStdio(os.Stdin, console, os.Stderr))
+	return ok, err
+}
+
+// fingerprint formats the SHA-256 fingerprint of cert like openssl does.
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// VerifyCA checks that the serving certificate of the server cfg connects to
+// verifies against the CA bundle. The server is dialed like the client of cfg
+// does, through its proxy and with its TLS settings.
+func VerifyCA(ca string, cfg *rest.Config) error {
+	tlsConfig, err := configTLS(cfg)
+	if err != nil {
+		return err
+	}
+	return verifyServerCA(ca, cfg.Host, cfg.TLSClientConfig.ServerName, tlsConfig, configProxy(cfg))
+}
+
+// VerifyServer checks the cluster CA against the server and TLS server name
+// written to the kubeconfig, the CA is discovered with the server of the
+// operator kubeconfig, which can be another one. The server is dialed through
+// the proxy of the kubeconfig, a server the operator can not reach is not
+// verified.
+func (p Params) VerifyServer() error {
+	if p.ClusterCA == "" || p.Server == "" && p.TLSServerName == "" {
+		return nil
+	}
+
+	server := p.Server
+	if server == "" {
+		server = p.ClusterEndpoint
+	}
+	proxy := http.ProxyFromEnvironment
+	if p.ProxyURL != "" {
+		u, err := url.Parse(p.ProxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy url %q: %w", p.ProxyURL, err)
+		}
+		proxy = http.ProxyURL(u)
+	}
+
+	err := verifyServerCA(p.ClusterCA, server, p.TLSServerName, &tls.Config{}, proxy)
+	if isUnreachable(err) {
+		log.Warningf("the cluster CA is not verified against the server: %v", err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cluster CA does not verify the server %s: %w", server, err)
+	}
+	return nil
+}
+
+func verifyServerCA(ca, server, serverName string, tlsConfig *tls.Config, proxy func(*http.Request) (*url.URL, error)) error {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(ca)) {
+		return fmt.Errorf("no certificate found in the CA bundle")
+	}
+	tlsConfig.RootCAs = pool
+	tlsConfig.InsecureSkipVerify = false
+
+	conn, err := dialServer(server, serverName, tlsConfig, proxy)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// configTLS returns the TLS settings of the client of cfg, like its client
+// certificate, the roots are replaced by the caller.
+func configTLS(cfg *rest.Config) (*tls.Config, error) {
+	tlsConfig, err := rest.TLSConfigFor(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("tls config of the kubeconfig err: %w", err)
+	}
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	return tlsConfig, nil
+}
+
+// configProxy returns the proxy of the client of cfg: the proxy-url of the
+// kubeconfig, or HTTPS_PROXY and NO_PROXY of the environment.
+func configProxy(cfg *rest.Config) func(*http.Request) (*url.URL, error) {
+	if cfg.Proxy != nil {
+		return cfg.Proxy
+	}
+	return http.ProxyFromEnvironment
+}
+
+// unreachableError tells that the server could not be connected to, unlike
+// a server whose certificate does not verify.
+type unreachableError struct {
+	server string
+	err    error
+}
+
+func (e *unreachableError) Error() string {
+	return fmt.Sprintf("server %s is unreachable: %v", e.server, e.err)
+}
+
+func (e *unreachableError) Unwrap() error {
+	return e.err
+}
+
+func isUnreachable(err error) bool {
+	var ue *unreachableError
+	return errors.As(err, &ue)
+}
+
+// dialServer opens a TLS connection to the server url through the proxy
+// picked by proxy, the server name is the host of the url unless serverName
+// is given. It returns an unreachableError unless the connection fails by
+// the verification of the certificate.
+func dialServer(server, serverName string, tlsConfig *tls.Config, proxy func(*http.Request) (*url.URL, error)) (*tls.Conn, error) {
+	u, err := parseServer(server)
+	if err != nil {
+		return nil, err
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "443")
+	}
+
+	tlsConfig.ServerName = serverName
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = u.Hostname()
+	}
+
+	raw, err := dialAddr(addr, proxy)
+	if err != nil {
+		return nil, &unreachableError{server: server, err: err}
+	}
+
+	conn := tls.Client(raw, tlsConfig)
+	raw.SetDeadline(time.Now().Add(caDialTimeout))
+	if err := conn.Handshake(); err != nil {
+		raw.Close()
+		var (
+			unknownAuthority x509.UnknownAuthorityError
+			invalid          x509.CertificateInvalidError
+			hostname         x509.HostnameError
+		)
+		if errors.As(err, &unknownAuthority) || errors.As(err, &invalid) || errors.As(err, &hostname) {
+			return nil, err
+		}
+		return nil, &unreachableError{server: server, err: err}
+	}
+	raw.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// dialAddr connects to addr directly or through the http, https or socks5
+// proxy proxy returns for it.
+func dialAddr(addr string, proxy func(*http.Request) (*url.URL, error)) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: caDialTimeout}
+
+	var proxyURL *url.URL
+	if proxy != nil {
+		var err error
+		req := &http.Request{URL: &url.URL{Scheme: "https", Host: addr}, Header: make(http.Header)}
+		if proxyURL, err = proxy(req); err != nil {
+			return nil, fmt.Errorf("get proxy of %s err: %w", addr, err)
+		}
+	}
+	if proxyURL == nil {
+		return dialer.Dial("tcp", addr)
+	}
+
+	switch proxyURL.Scheme {
+	case "socks5":
+		var auth *xproxy.Auth
+		if proxyURL.User != nil {
+			password, _ := proxyURL.User.Password()
+			auth = &xproxy.Auth{User: proxyURL.User.Username(), Password: password}
+		}
+		socks, err := xproxy.SOCKS5("tcp", proxyURL.Host, auth, dialer)
+		if err != nil {
+			return nil, err
+		}
+		return socks.Dial("tcp", addr)
+	case "http", "https":
+		return dialConnect(dialer, proxyURL, addr)
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+}
+
+// dialConnect tunnels to addr with a CONNECT request to the http(s) proxy.
+func dialConnect(dialer *net.Dialer, proxyURL *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+
+	conn, err := dialer.Dial("tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+	}
+	conn.SetDeadline(time.Now().Add(caDialTimeout))
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connect through proxy %s err: %w", proxyURL.Host, err)
+	}
+
+	// the client speaks first in the tunnel, nothing is buffered beyond the response
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connect through proxy %s err: %w", proxyURL.Host, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused to connect to %s: %s", proxyURL.Host, addr, resp.Status)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func parseServer(server string) (*url.URL, error) {
+	if !strings.Contains(server, "://") {
+		server = "https://" + server
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, fmt.Errorf("parse server %q err: %w", server, err)
+	}
+	return u, nil
+}
@@ -0,0 +1,191 @@
+package generate
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2/terminal"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	kubecmd "k8s.io/client-go/tools/clientcmd"
+	kubecmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func Test_DiscoverCA(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	serverCA := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	otherCA := selfSignedCA(t)
+
+	info := kubecmdapi.NewConfig()
+	info.Clusters[""] = &kubecmdapi.Cluster{Server: server.URL, CertificateAuthorityData: []byte(serverCA)}
+	infoData, err := kubecmd.Write(*info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewClient(fake.NewSimpleClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: rootCAConfigMap, Namespace: metav1.NamespaceDefault},
+			Data:       map[string]string{rootCAKey: otherCA},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster-info", Namespace: metav1.NamespacePublic},
+			Data:       map[string]string{"kubeconfig": string(infoData)},
+		},
+	))
+	cfg := &rest.Config{Host: server.URL}
+
+	// the kubeconfig has no CA and kube-root-ca.crt does not verify the server
+	ca, err := c.DiscoverCA(CASourceAuto, cfg, "", os.Stdout)
+	if err != nil || ca != serverCA {
+		t.Errorf("want the CA of cluster-info but got err %v", err)
+	}
+
+	if _, err := c.DiscoverCA(CASourceRootCA, cfg, "", os.Stdout); err == nil {
+		t.Errorf("want err for a CA not verifying the server but got nil")
+	}
+
+	cfg.TLSClientConfig.CAData = []byte(serverCA)
+	if ca, err := c.DiscoverCA(CASourceKubeconfig, cfg, "", os.Stdout); err != nil || ca != serverCA {
+		t.Errorf("want the CA of the kubeconfig but got err %v", err)
+	}
+
+	confirm := confirmHandshakeCA
+	defer func() { confirmHandshakeCA = confirm }()
+
+	confirmHandshakeCA = func(*x509.Certificate, terminal.FileWriter) (bool, error) { return true, nil }
+	if ca, err := c.DiscoverCA(CASourceHandshake, cfg, "", os.Stdout); err != nil || ca != serverCA {
+		t.Errorf("want the CA presented by the server but got err %v", err)
+	}
+
+	confirmHandshakeCA = func(*x509.Certificate, terminal.FileWriter) (bool, error) { return false, nil }
+	if _, err := c.DiscoverCA(CASourceHandshake, cfg, "", os.Stdout); err == nil {
+		t.Errorf("want err for a handshake CA which is not confirmed but got nil")
+	}
+
+	server.Close()
+	if _, err := c.DiscoverCA(CASourceAuto, cfg, "", os.Stdout); !isUnreachable(err) {
+		t.Errorf("want an unreachable err for a closed server but got %v", err)
+	}
+}
+
+func Test_VerifyServer(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	serverCA := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	// the certificate of httptest is valid for example.com
+	p := Params{ClusterEndpoint: "https://k8s.invalid", ClusterCA: serverCA, Server: server.URL, TLSServerName: "example.com"}
+	if err := p.VerifyServer(); err != nil {
+		t.Errorf("want the server verified but got %v", err)
+	}
+
+	p.TLSServerName = "k8s.example.org"
+	if err := p.VerifyServer(); err == nil {
+		t.Errorf("want err for a server name the certificate is not valid for but got nil")
+	}
+
+	p.ClusterCA = selfSignedCA(t)
+	p.TLSServerName = ""
+	if err := p.VerifyServer(); err == nil {
+		t.Errorf("want err for a CA not verifying the server but got nil")
+	}
+}
+
+func Test_VerifyCAProxy(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	serverCA := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	var connects int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "only CONNECT", http.StatusMethodNotAllowed)
+			return
+		}
+		atomic.AddInt32(&connects, 1)
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go func() {
+			io.Copy(upstream, conn)
+			upstream.Close()
+		}()
+		io.Copy(conn, upstream)
+		conn.Close()
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	cfg := &rest.Config{Host: server.URL, Proxy: http.ProxyURL(proxyURL)}
+	if err := VerifyCA(serverCA, cfg); err != nil {
+		t.Fatalf("want the server verified through the proxy but got %v", err)
+	}
+	if atomic.LoadInt32(&connects) != 1 {
+		t.Errorf("want the server dialed through the proxy but got %d CONNECT requests", connects)
+	}
+
+	err := VerifyCA(selfSignedCA(t), cfg)
+	if err == nil || isUnreachable(err) {
+		t.Errorf("want a verification err but got %v", err)
+	}
+
+	proxy.Close()
+	if err := VerifyCA(serverCA, cfg); !isUnreachable(err) {
+		t.Errorf("want an unreachable err for a closed proxy but got %v", err)
+	}
+
+	// the server of the kubeconfig may not be reachable by the operator
+	p := Params{ClusterCA: serverCA, Server: server.URL, ProxyURL: proxy.URL}
+	if err := p.VerifyServer(); err != nil {
+		t.Errorf("want an unreachable server not verified but got %v", err)
+	}
+}
+
+func selfSignedCA(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "other-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
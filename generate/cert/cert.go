@@ -1,6 +1,12 @@
 package cert
 
 import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/AlecAivazis/survey/v2"
 	cfssl "github.com/cloudflare/cfssl/csr"
 	"github.com/cloudflare/cfssl/log"
@@ -25,12 +31,12 @@ func New(c generate.Client) generate.Generator {
 }
 
 func (g *certKubeconfig) Generate(p *generate.Params) {
-	generate.KubeConfig(*p)
+	if err := generate.KubeConfig(*p); err != nil {
+		log.Fatalf("%v", err)
+	}
 }
 
 func (g *certKubeconfig) ParseParams(p *generate.Params) {
-	clusterRoleNames := g.client.GetClusterRoleNames()
-
 	var commonQ = []*survey.Question{
 		{
 			Name:     "username",
@@ -38,58 +44,25 @@ func (g *certKubeconfig) ParseParams(p *generate.Params) {
 			Validate: survey.Required,
 		},
 		{
-			Name: "saveAs",
+			Name: "groups",
 			Prompt: &survey.Input{
-				Message: "Please input kubeconfig save as name(default 'username.kubeconfig):",
+				Message: "Please input groups (certificate organizations) of the user, split by ',' (optional):",
 			},
 		},
 		{
-			Name: "scope",
-			Prompt: &survey.Select{
-				Message: "Please choose permission scope for this user:",
-				Options: []string{generate.ClusterScope, generate.NamespaceScope},
-				Default: generate.ClusterScope,
+			Name: "saveAs",
+			Prompt: &survey.Input{
+				Message: "Please input kubeconfig save as name(default 'username.kubeconfig):",
 			},
 		},
 	}
-	if err := survey.Ask(commonQ, p); err != nil {
+	if err := survey.Ask(generate.OmitSaveAs(commonQ, *p), p, p.AskOpt()); err != nil {
 		log.Fatalf("got questions answers err: %v", err)
 	}
 
-	if p.Scope == generate.ClusterScope {
-		var scopeQ = []*survey.Question{
-			{
-				Name: "clusterRoles",
-				Prompt: &survey.MultiSelect{
-					Message: "Please choose some cluster roles:",
-					Options: clusterRoleNames,
-				},
-			},
-		}
-		if err := survey.Ask(scopeQ, p); err != nil {
-			log.Fatalf("got questions answers err: %v", err)
-		}
-	} else {
-		var scopeQ = []*survey.Question{
-			{
-				Name: "namespaces",
-				Prompt: &survey.Input{
-					Message: "Please input namespaces you want to generate kubeconfig for, split by ',':",
-				},
-				Validate: survey.Required,
-			},
-			{
-				Name: "clusterRoles",
-				Prompt: &survey.MultiSelect{
-					Message: "Please choose some cluster roles:",
-					Options: clusterRoleNames,
-				},
-			},
-		}
-		if err := survey.Ask(scopeQ, p); err != nil {
-			log.Fatalf("got questions answers err: %v", err)
-		}
-	}
+	generate.CheckIdentity(p)
+
+	generate.AskScope(&g.client, p)
 }
 
 func (g *certKubeconfig) PreGenerate(p *generate.Params) {
@@ -100,6 +73,21 @@ func (g *certKubeconfig) PreGenerate(p *generate.Params) {
 			S: 256,
 		},
 	}
+	for _, group := range p.GroupSlice() {
+		csr.Names = append(csr.Names, cfssl.Name{O: group})
+	}
+
+	ok := false
+	prompt := &survey.Confirm{
+		Message: fmt.Sprintf("Submit a CSR with subject '%s'?", subject(p.Username, p.GroupSlice())),
+		Default: true,
+	}
+	if err := survey.AskOne(prompt, &ok, p.AskOpt()); err != nil {
+		log.Fatalf("got questions answers err: %v", err)
+	}
+	if !ok {
+		log.Fatalf("CSR is not submitted")
+	}
 
 	csrBytes, keyBytes, err := cfssl.ParseRequest(&csr)
 	if err != nil {
@@ -128,6 +116,16 @@ func (g *certKubeconfig) PreGenerate(p *generate.Params) {
 		log.Fatalf("get root client bundleCert err")
 	}
 
+	if lifetime := p.CertLifetime(); lifetime > 0 {
+		if err := checkLifetime(k8sCSR.Status.Certificate, lifetime); err != nil {
+			// the certificate stays valid, do not leave it readable in the CSR
+			if derr := g.client.DeleteK8sCSR(p.Username); derr != nil {
+				log.Errorf("delete k8s csr err: %v", derr)
+			}
+			log.Fatalf("%v", err)
+		}
+	}
+
 	bundleCert.ClientCert = string(k8sCSR.Status.Certificate)
 
 	p.ClientCert = bundleCert.ClientCert
@@ -135,7 +133,43 @@ func (g *certKubeconfig) PreGenerate(p *generate.Params) {
 
 }
 func (g *certKubeconfig) PostGenerate(p *generate.Params) {
-	if err := g.client.GenerateBinding("User", "", p.Username, p.ClusterRoles, p.NamespaceSlice()); err != nil {
-		log.Errorf("generate binding err: %v", err)
+	if err := g.client.ApplyCustomRole(p); err != nil {
+		log.Fatalf("apply custom role err: %v", err)
+	}
+
+	if err := g.client.GenerateBinding("User", *p); err != nil {
+		log.Fatalf("generate binding err: %v", err)
+	}
+}
+
+// lifetimeSlack tolerates the backdating and clock skew of signers.
+const lifetimeSlack = 10 * time.Minute
+
+// checkLifetime makes sure the issued certificate does not outlive lifetime,
+// the certificates/v1beta1 CSR can not request a lifetime from the signer.
+func checkLifetime(certPEM []byte, lifetime time.Duration) error {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return fmt.Errorf("decode issued certificate err")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("parse issued certificate err: %w", err)
+	}
+
+	if deadline := time.Now().Add(lifetime + lifetimeSlack); cert.NotAfter.After(deadline) {
+		return fmt.Errorf("issued certificate expires at %s, which is beyond the lifetime %s, the CSR is deleted and the kubeconfig is not written",
+			cert.NotAfter.Format(time.RFC3339), lifetime)
+	}
+	return nil
+}
+
+// subject formats the certificate subject like openssl does, e.g. CN=alice, O=dev.
+func subject(cn string, organizations []string) string {
+	parts := []string{"CN=" + cn}
+	for _, o := range organizations {
+		parts = append(parts, "O="+o)
 	}
+	return strings.Join(parts, ", ")
 }
@@ -1,10 +1,12 @@
 package generate
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"github.com/cloudflare/cfssl/log"
+	authenticationv1 "k8s.io/api/authentication/v1"
 	certv1beta1 "k8s.io/api/certificates/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
@@ -25,117 +27,6 @@ func NewClient(client kubernetes.Interface) *Client {
 	return &Client{client: client}
 }
 
-func (kt *Client) createNsIfNotExist(namespace string) error {
-	_, err := kt.client.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
-	if err != nil && apierrors.IsNotFound(err) {
-		ns := &corev1.Namespace{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: namespace,
-			},
-		}
-
-		if _, err := kt.client.CoreV1().Namespaces().Create(ns); err != nil {
-			return err
-		}
-
-	}
-
-	return nil
-}
-
-func (kt *Client) reCreateRoleBinding(roleBindingType, name, username, namespace, roleRef, saNameSpace string) error {
-	_, err := kt.client.RbacV1().RoleBindings(namespace).Get(name, metav1.GetOptions{})
-	if err != nil && !apierrors.IsNotFound(err) {
-		return err
-	}
-
-	if err == nil {
-		if err := kt.client.RbacV1().RoleBindings(namespace).Delete(name, &metav1.DeleteOptions{}); err != nil {
-			return err
-		}
-	}
-	var subj []rbacv1.Subject
-	if roleBindingType == "User" {
-		subj = []rbacv1.Subject{
-			{
-				Kind: rbacv1.UserKind,
-				Name: username,
-			},
-		}
-	} else {
-		subj = []rbacv1.Subject{
-			{
-				Kind:      rbacv1.ServiceAccountKind,
-				Name:      username,
-				Namespace: saNameSpace,
-			},
-		}
-	}
-	rb := &rbacv1.RoleBinding{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: namespace,
-		},
-		Subjects: subj,
-		RoleRef: rbacv1.RoleRef{
-			Kind: "ClusterRole",
-			Name: roleRef,
-		},
-	}
-
-	if _, err := kt.client.RbacV1().RoleBindings(namespace).Create(rb); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (kt *Client) reCreateClusterRoleBinding(roleBindingType, name, username, roleRef, saNameSpace string) error {
-	_, err := kt.client.RbacV1().ClusterRoleBindings().Get(name, metav1.GetOptions{})
-	if err != nil && !apierrors.IsNotFound(err) {
-		return err
-	}
-
-	if err == nil {
-		if err := kt.client.RbacV1().ClusterRoleBindings().Delete(name, &metav1.DeleteOptions{}); err != nil {
-			return err
-		}
-	}
-	var subj []rbacv1.Subject
-	if roleBindingType == "User" {
-		subj = []rbacv1.Subject{
-			{
-				Kind: rbacv1.UserKind,
-				Name: username,
-			},
-		}
-	} else {
-		subj = []rbacv1.Subject{
-			{
-				Kind:      rbacv1.ServiceAccountKind,
-				Name:      username,
-				Namespace: saNameSpace,
-			},
-		}
-	}
-	crb := &rbacv1.ClusterRoleBinding{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: name,
-		},
-		Subjects: subj,
-		RoleRef: rbacv1.RoleRef{
-			Kind: "ClusterRole",
-			Name: roleRef,
-		},
-	}
-
-	if _, err := kt.client.RbacV1().ClusterRoleBindings().Create(crb); err != nil {
-		return err
-	}
-
-	return nil
-}
-
 func (kt *Client) ReCreateK8sCSR(cn, csrStr string) error {
 	k8sCSR := certv1beta1.CertificateSigningRequest{
 		ObjectMeta: metav1.ObjectMeta{
@@ -148,21 +39,30 @@ func (kt *Client) ReCreateK8sCSR(cn, csrStr string) error {
 			},
 		},
 	}
-	err := kt.client.CertificatesV1beta1().CertificateSigningRequests().Delete(k8sCSR.Name, &metav1.DeleteOptions{})
+	err := kt.client.CertificatesV1beta1().CertificateSigningRequests().Delete(context.TODO(), k8sCSR.Name, metav1.DeleteOptions{})
 	if err != nil && !apierrors.IsNotFound(err) {
 		return err
 	}
 
-	if _, err := kt.client.CertificatesV1beta1().CertificateSigningRequests().Create(&k8sCSR); err != nil {
+	if _, err := kt.client.CertificatesV1beta1().CertificateSigningRequests().Create(context.TODO(), &k8sCSR, metav1.CreateOptions{}); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// DeleteK8sCSR deletes the CSR name, a missing CSR is not an error.
+func (kt *Client) DeleteK8sCSR(name string) error {
+	err := kt.client.CertificatesV1beta1().CertificateSigningRequests().Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
 func (kt *Client) WaitForK8sCsrReady(name string) (csr *certv1beta1.CertificateSigningRequest, err error) {
 	for i := 0; i < 5; i++ {
-		csr, err = kt.client.CertificatesV1beta1().CertificateSigningRequests().Get(name, metav1.GetOptions{})
+		csr, err = kt.client.CertificatesV1beta1().CertificateSigningRequests().Get(context.TODO(), name, metav1.GetOptions{})
 		if err != nil {
 			log.Errorf("get %s csr err: %v", err)
 			time.Sleep(time.Second)
@@ -195,7 +95,7 @@ func (kt *Client) ApprovalK8sCSR(name string) error {
 		},
 	}
 
-	if _, err := kt.client.CertificatesV1beta1().CertificateSigningRequests().UpdateApproval(k8sCSR); err != nil {
+	if _, err := kt.client.CertificatesV1beta1().CertificateSigningRequests().UpdateApproval(context.TODO(), k8sCSR, metav1.UpdateOptions{}); err != nil {
 		return err
 	}
 
@@ -203,11 +103,7 @@ func (kt *Client) ApprovalK8sCSR(name string) error {
 }
 
 func (kt *Client) GetServiceAccountNames(nameSpace string) []string {
-	if err := kt.createNsIfNotExist(nameSpace); err != nil {
-		log.Fatalf("create ns: %v err: %v", nameSpace, err)
-	}
-
-	serviceAccounts, err := kt.client.CoreV1().ServiceAccounts(nameSpace).List(metav1.ListOptions{})
+	serviceAccounts, err := kt.client.CoreV1().ServiceAccounts(nameSpace).List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
 		log.Fatalf("list cluster role err: %v", err)
 	}
@@ -219,7 +115,7 @@ func (kt *Client) GetServiceAccountNames(nameSpace string) []string {
 }
 
 func (kt *Client) GetClusterRoleNames() []string {
-	clusterRoles, err := kt.client.RbacV1().ClusterRoles().List(metav1.ListOptions{})
+	clusterRoles, err := kt.client.RbacV1().ClusterRoles().List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
 		return nil
 	}
@@ -232,29 +128,119 @@ func (kt *Client) GetClusterRoleNames() []string {
 	return clusterRoleNames
 }
 
-func (kt *Client) GenerateBinding(roleBindingType, saNameSpace, username string, clusterRoles []string, namespaces []string) error {
-	if len(namespaces) == 0 {
-		for _, cr := range clusterRoles {
-			name := fmt.Sprintf("%s-%s", username, cr)
-			if err := kt.reCreateClusterRoleBinding(roleBindingType, name, username, cr, saNameSpace); err != nil {
-				return fmt.Errorf("create cluster role binding for %s err: %w", username, err)
-			}
-			log.Infof("create cluster role binding %s success", name)
+// GetRoleNames returns the names of the Roles in any of namespaces.
+func (kt *Client) GetRoleNames(namespaces []string) []string {
+	var roleNames []string
+
+	for _, ns := range namespaces {
+		roles, err := kt.client.RbacV1().Roles(ns).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			continue
 		}
-	} else {
-		for _, ns := range namespaces {
-			if err := kt.createNsIfNotExist(ns); err != nil {
-				return fmt.Errorf("create namespace %s,err: %w", ns, err)
-			}
 
-			for _, cr := range clusterRoles {
-				name := fmt.Sprintf("%s-%s", username, cr)
-				if err := kt.reCreateRoleBinding(roleBindingType, name, username, ns, cr, saNameSpace); err != nil {
-					return fmt.Errorf("create role binding for %s err: %w", username, err)
-				}
-				log.Infof("create role binding %s in %s namespace success", name, ns)
-			}
+		for _, item := range roles.Items {
+			roleNames = append(roleNames, item.Name)
 		}
 	}
+	return uniqueStrings(roleNames)
+}
+
+// GetServiceAccountToken returns a token of the service account, a bound token
+// is requested if lifetime is set, or the legacy token secret is used.
+func (kt *Client) GetServiceAccountToken(namespace, name string, lifetime time.Duration) (string, error) {
+	if lifetime > 0 {
+		seconds := int64(lifetime / time.Second)
+		tr := &authenticationv1.TokenRequest{
+			Spec: authenticationv1.TokenRequestSpec{
+				ExpirationSeconds: &seconds,
+			},
+		}
+		res, err := kt.client.CoreV1().ServiceAccounts(namespace).CreateToken(context.TODO(), name, tr, metav1.CreateOptions{})
+		if err != nil {
+			return "", err
+		}
+		return res.Status.Token, nil
+	}
+
+	sa, err := kt.client.CoreV1().ServiceAccounts(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	if len(sa.Secrets) == 0 {
+		return "", fmt.Errorf("service account %s/%s has no token secret", namespace, name)
+	}
+
+	secret, err := kt.client.CoreV1().Secrets(namespace).Get(context.TODO(), sa.Secrets[0].Name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	return string(secret.Data["token"]), nil
+}
+
+func (kt *Client) CreateSAIfNotExist(namespace, name string) error {
+	_, err := kt.client.CoreV1().ServiceAccounts(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err == nil || !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	sa := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+	}
+
+	if _, err := kt.client.CoreV1().ServiceAccounts(namespace).Create(context.TODO(), sa, metav1.CreateOptions{}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ImpersonatorRoleName is the name of the cluster role (and cluster role binding)
+// which allows the gateway identity to impersonate username.
+func ImpersonatorRoleName(username string) string {
+	return fmt.Sprintf("gen-kubecfg:impersonate:%s", username)
+}
+
+// impersonatorRules only allow to impersonate username and its groups.
+func impersonatorRules(username string, groups []string) []rbacv1.PolicyRule {
+	rules := []rbacv1.PolicyRule{
+		{
+			APIGroups:     []string{""},
+			Resources:     []string{"users"},
+			Verbs:         []string{"impersonate"},
+			ResourceNames: []string{username},
+		},
+	}
+	if len(groups) > 0 {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups:     []string{""},
+			Resources:     []string{"groups"},
+			Verbs:         []string{"impersonate"},
+			ResourceNames: groups,
+		})
+	}
+	return rules
+}
+
+// ReCreateImpersonatorRole creates a cluster role which only allows to impersonate
+// username and its groups, and binds it to the gateway service account.
+func (kt *Client) ReCreateImpersonatorRole(username string, groups []string, saName, saNameSpace string) error {
+	name := ImpersonatorRoleName(username)
+
+	if err := kt.applyClusterRole(name, impersonatorRules(username, groups), ""); err != nil {
+		return err
+	}
+
+	subject := NewSubject("ServiceAccount", saName, saNameSpace)
+	roleRef := rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name}
+	res, err := kt.reconcileClusterRoleBinding(name, subject, roleRef, false)
+	if err != nil {
+		return err
+	}
+	log.Infof("cluster role binding %s %s", name, res)
+
 	return nil
 }
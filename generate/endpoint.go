@@ -0,0 +1,331 @@
+package generate
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/cloudflare/cfssl/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	kubecmd "k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	EndpointSourceKubeconfig   = "kubeconfig"
+	EndpointSourceClusterInfo  = "cluster-info"
+	EndpointSourceEndpoints    = "endpoints"
+	EndpointSourceLoadBalancer = "load-balancer"
+	EndpointSourceIngress      = "ingress"
+
+	// ServerAuto as the server option picks the preferred discovered endpoint
+	// without asking.
+	ServerAuto = "auto"
+
+	// APIServerAnnotation marks the LoadBalancer services and ingresses which
+	// front the API server, e.g. gen-kubecfg/api-server: "true".
+	APIServerAnnotation = "gen-kubecfg/api-server"
+)
+
+// Endpoint is a candidate server url of the generated kubeconfig.
+type Endpoint struct {
+	Source string
+	Server string
+
+	// Reachable, Trusted and NameMatch are set by ProbeEndpoints: the server
+	// answers the TLS handshake, its certificate verifies against the cluster
+	// CA and is valid for the server name.
+	Reachable bool
+	Trusted   bool
+	NameMatch bool
+	Err       error
+}
+
+// Usable tells if a recipient can connect to the endpoint from here.
+func (e Endpoint) Usable() bool {
+	return e.Reachable && e.Trusted && e.NameMatch
+}
+
+// Loopback tells if the endpoint is only reachable from this machine, like
+// the 127.0.0.1 server of kind clusters.
+func (e Endpoint) Loopback() bool {
+	u, err := parseServer(e.Server)
+	if err != nil {
+		return false
+	}
+	if u.Hostname() == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(u.Hostname())
+	return ip != nil && ip.IsLoopback()
+}
+
+// DiscoverEndpoints returns the candidate server urls of the cluster: the
+// server of the operator kubeconfig, the kube-public/cluster-info server, the
+// addresses of the default/kubernetes endpoints and the LoadBalancer services
+// and ingresses annotated with APIServerAnnotation. A source which can not be
+// read is skipped.
+func (kt *Client) DiscoverEndpoints(kubeconfigServer string) []Endpoint {
+	var res []Endpoint
+	seen := make(map[string]bool)
+	add := func(source, server string) {
+		if server == "" || seen[server] {
+			return
+		}
+		seen[server] = true
+		res = append(res, Endpoint{Source: source, Server: server})
+	}
+
+	add(EndpointSourceKubeconfig, kubeconfigServer)
+
+	for _, server := range kt.clusterInfoServers() {
+		add(EndpointSourceClusterInfo, server)
+	}
+
+	servers, apiPorts := kt.endpointsServers()
+	for _, server := range servers {
+		add(EndpointSourceEndpoints, server)
+	}
+
+	for _, server := range kt.loadBalancerServers(apiPorts) {
+		add(EndpointSourceLoadBalancer, server)
+	}
+
+	for _, server := range kt.ingressServers() {
+		add(EndpointSourceIngress, server)
+	}
+
+	return res
+}
+
+func (kt *Client) clusterInfoServers() []string {
+	cm, err := kt.client.CoreV1().ConfigMaps(metav1.NamespacePublic).Get(context.TODO(), "cluster-info", metav1.GetOptions{})
+	if err != nil {
+		log.Debugf("get cluster-info err: %v", err)
+		return nil
+	}
+
+	cfg, err := kubecmd.Load([]byte(cm.Data["kubeconfig"]))
+	if err != nil {
+		log.Debugf("load cluster-info kubeconfig err: %v", err)
+		return nil
+	}
+
+	var res []string
+	for _, cluster := range cfg.Clusters {
+		res = append(res, cluster.Server)
+	}
+	return res
+}
+
+// endpointsServers returns the addresses of the default/kubernetes endpoints
+// and the ports the API server listens on.
+func (kt *Client) endpointsServers() ([]string, map[int32]bool) {
+	ep, err := kt.client.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.TODO(), "kubernetes", metav1.GetOptions{})
+	if err != nil {
+		log.Debugf("get kubernetes endpoints err: %v", err)
+		return nil, nil
+	}
+
+	var res []string
+	ports := make(map[int32]bool)
+	for _, subset := range ep.Subsets {
+		for _, port := range subset.Ports {
+			if port.Name != "https" {
+				continue
+			}
+			ports[port.Port] = true
+			for _, addr := range subset.Addresses {
+				res = append(res, httpsURL(addr.IP, port.Port))
+			}
+		}
+	}
+	return res, ports
+}
+
+func (kt *Client) loadBalancerServers(apiPorts map[int32]bool) []string {
+	svcs, err := kt.client.CoreV1().Services(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		log.Debugf("list services err: %v", err)
+		return nil
+	}
+
+	var res []string
+	for _, svc := range svcs.Items {
+		if svc.Annotations[APIServerAnnotation] != "true" || svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			continue
+		}
+		port, ok := apiServerPort(svc.Spec.Ports, apiPorts)
+		if !ok {
+			log.Debugf("not found the API server port of service %s/%s, want a port named https", svc.Namespace, svc.Name)
+			continue
+		}
+		for _, ing := range svc.Status.LoadBalancer.Ingress {
+			res = append(res, httpsURL(loadBalancerHost(ing), port))
+		}
+	}
+	return res
+}
+
+// apiServerPort returns the service port named https, or else the one whose
+// target port is a port of the API server.
+func apiServerPort(ports []corev1.ServicePort, apiPorts map[int32]bool) (int32, bool) {
+	for _, port := range ports {
+		if port.Name == "https" {
+			return port.Port, true
+		}
+	}
+	for _, port := range ports {
+		target := port.TargetPort
+		if target.Type == intstr.String && target.StrVal == "https" || target.Type == intstr.Int && apiPorts[target.IntVal] {
+			return port.Port, true
+		}
+	}
+	return 0, false
+}
+
+func (kt *Client) ingressServers() []string {
+	ings, err := kt.client.NetworkingV1().Ingresses(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		log.Debugf("list ingresses err: %v", err)
+		return nil
+	}
+
+	var res []string
+	for _, ing := range ings.Items {
+		if ing.Annotations[APIServerAnnotation] != "true" {
+			continue
+		}
+		for _, rule := range ing.Spec.Rules {
+			if rule.Host != "" {
+				res = append(res, httpsURL(rule.Host, 443))
+			}
+		}
+		for _, lb := range ing.Status.LoadBalancer.Ingress {
+			res = append(res, httpsURL(loadBalancerHost(lb), 443))
+		}
+	}
+	return res
+}
+
+func loadBalancerHost(ing corev1.LoadBalancerIngress) string {
+	if ing.Hostname != "" {
+		return ing.Hostname
+	}
+	return ing.IP
+}
+
+func httpsURL(host string, port int32) string {
+	if host == "" {
+		return ""
+	}
+	if port == 443 {
+		return "https://" + host
+	}
+	return "https://" + net.JoinHostPort(host, strconv.Itoa(int(port)))
+}
+
+// ProbeEndpoints connects to every endpoint concurrently and checks its
+// serving certificate against the cluster CA and tlsServerName, the host of
+// the endpoint is checked if tlsServerName is empty. The system roots are
+// used if ca is empty.
+func ProbeEndpoints(endpoints []Endpoint, ca, tlsServerName string) {
+	var roots *x509.CertPool
+	if ca != "" {
+		roots = x509.NewCertPool()
+		roots.AppendCertsFromPEM([]byte(ca))
+	}
+
+	var wg sync.WaitGroup
+	for i := range endpoints {
+		wg.Add(1)
+		go func(e *Endpoint) {
+			defer wg.Done()
+			probeEndpoint(e, roots, tlsServerName)
+		}(&endpoints[i])
+	}
+	wg.Wait()
+}
+
+func probeEndpoint(e *Endpoint, roots *x509.CertPool, tlsServerName string) {
+	conn, err := dialServer(e.Server, tlsServerName, &tls.Config{InsecureSkipVerify: true}, http.ProxyFromEnvironment)
+	if err != nil {
+		e.Err = err
+		return
+	}
+	defer conn.Close()
+	e.Reachable = true
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		e.Err = fmt.Errorf("the server presented no certificate")
+		return
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates}); err != nil {
+		e.Err = err
+	} else {
+		e.Trusted = true
+	}
+
+	name := tlsServerName
+	if name == "" {
+		if u, err := parseServer(e.Server); err == nil {
+			name = u.Hostname()
+		}
+	}
+	if err := certs[0].VerifyHostname(name); err != nil {
+		if e.Err == nil {
+			e.Err = err
+		}
+	} else {
+		e.NameMatch = true
+	}
+}
+
+// PrintEndpoints writes the probe results of endpoints.
+func PrintEndpoints(w io.Writer, endpoints []Endpoint) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	fmt.Fprintln(tw, "SERVER\tSOURCE\tREACHABLE\tTRUSTED\tNAME MATCH\tNOTE")
+	for _, e := range endpoints {
+		note := ""
+		if e.Loopback() {
+			note = "loopback, only reachable from this machine"
+		} else if e.Err != nil {
+			note = e.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Server, e.Source, yesNo(e.Reachable), yesNo(e.Trusted), yesNo(e.NameMatch), note)
+	}
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// PreferredEndpoint returns the first usable endpoint which is not a loopback
+// address, or the first endpoint if none is.
+func PreferredEndpoint(endpoints []Endpoint) Endpoint {
+	for _, e := range endpoints {
+		if e.Usable() && !e.Loopback() {
+			return e
+		}
+	}
+	return endpoints[0]
+}
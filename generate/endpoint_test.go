@@ -0,0 +1,130 @@
+package generate
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_DiscoverEndpoints(t *testing.T) {
+	annotated := map[string]string{APIServerAnnotation: "true"}
+	c := NewClient(fake.NewSimpleClientset(
+		&corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "kubernetes", Namespace: metav1.NamespaceDefault},
+			Subsets: []corev1.EndpointSubset{{
+				Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}, {IP: "10.0.0.2"}},
+				Ports:     []corev1.EndpointPort{{Name: "https", Port: 6443}},
+			}},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "kube-system", Annotations: annotated},
+			Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, Ports: []corev1.ServicePort{
+				{Name: "metrics", Port: 9090, TargetPort: intstr.FromInt(9090)},
+				{Name: "api", Port: 443, TargetPort: intstr.FromInt(6443)},
+			}},
+			Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{{Hostname: "api-lb.example.com"}},
+			}},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, Ports: []corev1.ServicePort{{Port: 443}}},
+			Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{{IP: "1.2.3.4"}},
+			}},
+		},
+		&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "kube-system", Annotations: annotated},
+			Spec:       networkingv1.IngressSpec{Rules: []networkingv1.IngressRule{{Host: "k8s.example.com"}}},
+		},
+	))
+
+	var servers []string
+	for _, e := range c.DiscoverEndpoints("https://127.0.0.1:6443") {
+		servers = append(servers, e.Source+" "+e.Server)
+	}
+	want := []string{
+		"kubeconfig https://127.0.0.1:6443",
+		"endpoints https://10.0.0.1:6443",
+		"endpoints https://10.0.0.2:6443",
+		"load-balancer https://api-lb.example.com",
+		"ingress https://k8s.example.com",
+	}
+	if !reflect.DeepEqual(servers, want) {
+		t.Errorf("want %v but got %v", want, servers)
+	}
+}
+
+func Test_apiServerPort(t *testing.T) {
+	apiPorts := map[int32]bool{6443: true}
+	cases := []struct {
+		name  string
+		ports []corev1.ServicePort
+		want  int32
+		found bool
+	}{
+		{name: "named", ports: []corev1.ServicePort{{Port: 80}, {Name: "https", Port: 8443}}, want: 8443, found: true},
+		{name: "target port", ports: []corev1.ServicePort{{Port: 80, TargetPort: intstr.FromInt(8080)}, {Port: 443, TargetPort: intstr.FromInt(6443)}}, want: 443, found: true},
+		{name: "named target port", ports: []corev1.ServicePort{{Port: 443, TargetPort: intstr.FromString("https")}}, want: 443, found: true},
+		{name: "none", ports: []corev1.ServicePort{{Port: 80, TargetPort: intstr.FromInt(8080)}}},
+	}
+
+	for _, c := range cases {
+		got, found := apiServerPort(c.ports, apiPorts)
+		if got != c.want || found != c.found {
+			t.Errorf("%s: want %d %v but got %d %v", c.name, c.want, c.found, got, found)
+		}
+	}
+}
+
+func Test_ProbeEndpoints(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	closed := httptest.NewTLSServer(http.NotFoundHandler())
+	closed.Close()
+
+	endpoints := []Endpoint{{Server: server.URL}, {Server: closed.URL}}
+	ProbeEndpoints(endpoints, ca, "")
+	if !endpoints[0].Usable() {
+		t.Errorf("want usable endpoint but got %+v", endpoints[0])
+	}
+	if endpoints[1].Reachable {
+		t.Errorf("want unreachable endpoint but got %+v", endpoints[1])
+	}
+
+	endpoints = []Endpoint{{Server: server.URL}}
+	ProbeEndpoints(endpoints, ca, "k8s.example.org")
+	if !endpoints[0].Trusted || endpoints[0].NameMatch {
+		t.Errorf("want trusted endpoint with name mismatch but got %+v", endpoints[0])
+	}
+
+	endpoints = []Endpoint{{Server: server.URL}}
+	ProbeEndpoints(endpoints, selfSignedCA(t), "")
+	if endpoints[0].Trusted {
+		t.Errorf("want untrusted endpoint but got %+v", endpoints[0])
+	}
+}
+
+func Test_PreferredEndpoint(t *testing.T) {
+	endpoints := []Endpoint{
+		{Server: "https://127.0.0.1:6443", Reachable: true, Trusted: true, NameMatch: true},
+		{Server: "https://10.0.0.1:6443", Reachable: false},
+		{Server: "https://k8s.example.com", Reachable: true, Trusted: true, NameMatch: true},
+	}
+	if e := PreferredEndpoint(endpoints); e.Server != "https://k8s.example.com" {
+		t.Errorf("want the usable non loopback endpoint but got %s", e.Server)
+	}
+	if e := PreferredEndpoint(endpoints[:2]); e.Server != "https://127.0.0.1:6443" {
+		t.Errorf("want the first endpoint but got %s", e.Server)
+	}
+}
@@ -0,0 +1,155 @@
+package generate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// holdsRules tells if the operator holds all the rules in namespace, or
+// cluster-wide if namespace is empty. The rules of a namespace are listed by a
+// SelfSubjectRulesReview, which is namespaced, so a cluster-wide grant is
+// checked by an access review per permission instead.
+func (kt *Client) holdsRules(namespace string, rules []rbacv1.PolicyRule) (bool, error) {
+	if namespace == "" {
+		return kt.reviewRules(rules)
+	}
+
+	review := &authorizationv1.SelfSubjectRulesReview{
+		Spec: authorizationv1.SelfSubjectRulesReviewSpec{Namespace: namespace},
+	}
+	status, err := kt.client.AuthorizationV1().SelfSubjectRulesReviews().Create(context.TODO(), review, metav1.CreateOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	for _, attr := range expandRules(namespace, rules) {
+		if !rulesCover(status.Status, attr) {
+			if status.Status.Incomplete {
+				return false, fmt.Errorf("the rules review is incomplete: %s", status.Status.EvaluationError)
+			}
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (kt *Client) reviewRules(rules []rbacv1.PolicyRule) (bool, error) {
+	for _, attr := range expandRules("", rules) {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes:    attr.ResourceAttributes,
+				NonResourceAttributes: attr.NonResourceAttributes,
+			},
+		}
+		status, err := kt.client.AuthorizationV1().SelfSubjectAccessReviews().Create(context.TODO(), review, metav1.CreateOptions{})
+		if err != nil {
+			return false, err
+		}
+		if !status.Status.Allowed {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// expandRules splits rules into single permissions, a wildcard stays a
+// wildcard which is only held by a wildcard too.
+func expandRules(namespace string, rules []rbacv1.PolicyRule) []authorizationv1.SelfSubjectAccessReviewSpec {
+	var res []authorizationv1.SelfSubjectAccessReviewSpec
+	for _, rule := range rules {
+		for _, verb := range rule.Verbs {
+			for _, url := range rule.NonResourceURLs {
+				res = append(res, authorizationv1.SelfSubjectAccessReviewSpec{
+					NonResourceAttributes: &authorizationv1.NonResourceAttributes{Path: url, Verb: verb},
+				})
+			}
+
+			names := rule.ResourceNames
+			if len(names) == 0 {
+				names = []string{""}
+			}
+			for _, group := range rule.APIGroups {
+				for _, resource := range rule.Resources {
+					resource, subresource := splitResource(resource)
+					for _, name := range names {
+						res = append(res, authorizationv1.SelfSubjectAccessReviewSpec{
+							ResourceAttributes: &authorizationv1.ResourceAttributes{
+								Namespace:   namespace,
+								Verb:        verb,
+								Group:       group,
+								Resource:    resource,
+								Subresource: subresource,
+								Name:        name,
+							},
+						})
+					}
+				}
+			}
+		}
+	}
+	return res
+}
+
+func splitResource(resource string) (string, string) {
+	parts := strings.SplitN(resource, "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// rulesCover tells if the rules of a review grant the permission attr.
+func rulesCover(status authorizationv1.SubjectRulesReviewStatus, attr authorizationv1.SelfSubjectAccessReviewSpec) bool {
+	if nr := attr.NonResourceAttributes; nr != nil {
+		for _, rule := range status.NonResourceRules {
+			if matchValue(rule.Verbs, nr.Verb) && matchURL(rule.NonResourceURLs, nr.Path) {
+				return true
+			}
+		}
+		return false
+	}
+
+	ra := attr.ResourceAttributes
+	resource := ra.Resource
+	if ra.Subresource != "" {
+		resource += "/" + ra.Subresource
+	}
+	for _, rule := range status.ResourceRules {
+		if !matchValue(rule.Verbs, ra.Verb) || !matchValue(rule.APIGroups, ra.Group) || !matchResource(rule.Resources, resource) {
+			continue
+		}
+		if len(rule.ResourceNames) == 0 || ra.Name != "" && contains(rule.ResourceNames, ra.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchValue(values []string, v string) bool {
+	return contains(values, v) || contains(values, "*")
+}
+
+func matchResource(resources []string, resource string) bool {
+	if matchValue(resources, resource) {
+		return true
+	}
+	// */scale matches the scale subresource of every resource
+	if i := strings.Index(resource, "/"); i >= 0 {
+		return contains(resources, "*"+resource[i:])
+	}
+	return false
+}
+
+func matchURL(urls []string, path string) bool {
+	for _, u := range urls {
+		if u == path || u == "*" || strings.HasSuffix(u, "*") && strings.HasPrefix(path, strings.TrimSuffix(u, "*")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package generate
+
+import (
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+func Test_rulesCover(t *testing.T) {
+	status := authorizationv1.SubjectRulesReviewStatus{
+		ResourceRules: []authorizationv1.ResourceRule{
+			{APIGroups: []string{"apps"}, Resources: []string{"deployments", "*/scale"}, Verbs: []string{"get", "update"}},
+			{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get"}, ResourceNames: []string{"app"}},
+		},
+		NonResourceRules: []authorizationv1.NonResourceRule{{NonResourceURLs: []string{"/healthz/*"}, Verbs: []string{"get"}}},
+	}
+
+	cases := []struct {
+		name  string
+		rule  rbacv1.PolicyRule
+		cover bool
+	}{
+		{name: "held", rule: rbacv1.PolicyRule{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"get"}}, cover: true},
+		{name: "subresource wildcard", rule: rbacv1.PolicyRule{APIGroups: []string{"apps"}, Resources: []string{"deployments/scale"}, Verbs: []string{"update"}}, cover: true},
+		{name: "other verb", rule: rbacv1.PolicyRule{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"delete"}}},
+		{name: "wildcard", rule: rbacv1.PolicyRule{APIGroups: []string{"apps"}, Resources: []string{"*"}, Verbs: []string{"get"}}},
+		{name: "named", rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get"}, ResourceNames: []string{"app"}}, cover: true},
+		{name: "unnamed", rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get"}}},
+		{name: "non resource", rule: rbacv1.PolicyRule{NonResourceURLs: []string{"/healthz/ready"}, Verbs: []string{"get"}}, cover: true},
+	}
+
+	for _, c := range cases {
+		cover := true
+		for _, attr := range expandRules("dev", []rbacv1.PolicyRule{c.rule}) {
+			cover = cover && rulesCover(status, attr)
+		}
+		if cover != c.cover {
+			t.Errorf("%s: want cover %v but got %v", c.name, c.cover, cover)
+		}
+	}
+}
@@ -3,13 +3,17 @@ package generate
 import (
 	"fmt"
 	"io/ioutil"
+	"sort"
 
 	"github.com/cloudflare/cfssl/log"
 	kubecmd "k8s.io/client-go/tools/clientcmd"
 	kubecmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
-func GetClusterName(kubeConfig string) (string, error) {
+// GetClusterName returns the name of the cluster which context refers to in
+// kubeConfig, the current context is used if context is empty. A non-empty
+// cluster overrides the cluster of the context, like kubectl --cluster does.
+func GetClusterName(kubeConfig, context, cluster string) (string, error) {
 	data, err := ioutil.ReadFile(kubeConfig)
 	if err != nil {
 		return "", err
@@ -24,21 +28,57 @@ func GetClusterName(kubeConfig string) (string, error) {
 		return "", fmt.Errorf("not found any cluster in this kubeconfig")
 	}
 
-	if cfg.CurrentContext == "" {
+	if cluster != "" {
+		if _, ok := cfg.Clusters[cluster]; !ok {
+			return "", fmt.Errorf("not found cluster %q in this kubeconfig", cluster)
+		}
+		if _, ok := cfg.Contexts[context]; context != "" && !ok {
+			return "", fmt.Errorf("not found context %q in this kubeconfig", context)
+		}
+		return cluster, nil
+	}
+
+	if context == "" {
+		context = cfg.CurrentContext
+	}
+
+	if context == "" {
+		names := make([]string, 0, len(cfg.Clusters))
 		for k := range cfg.Clusters {
-			return k, nil
+			names = append(names, k)
 		}
+		sort.Strings(names)
+		return names[0], nil
+	}
+
+	ctx, ok := cfg.Contexts[context]
+	if !ok {
+		return "", fmt.Errorf("not found context %q in this kubeconfig", context)
 	}
 
-	return cfg.CurrentContext, nil
+	if _, ok := cfg.Clusters[ctx.Cluster]; !ok {
+		return "", fmt.Errorf("not found cluster %q of context %q in this kubeconfig", ctx.Cluster, context)
+	}
+
+	return ctx.Cluster, nil
 }
 
-func KubeConfig(input Params) {
+// BuildKubeConfig builds the kubeconfig of the user from input.
+func BuildKubeConfig(input Params) *kubecmdapi.Config {
 	kubecfg := kubecmdapi.NewConfig()
 
 	cluster := kubecmdapi.NewCluster()
 	cluster.Server = input.ClusterEndpoint
-	cluster.CertificateAuthorityData = []byte(input.ClusterCA)
+	if input.Server != "" {
+		cluster.Server = input.Server
+	}
+	if input.CAFileRef != "" {
+		cluster.CertificateAuthority = input.CAFileRef
+	} else {
+		cluster.CertificateAuthorityData = []byte(input.ClusterCA)
+	}
+	cluster.TLSServerName = input.TLSServerName
+	cluster.ProxyURL = input.ProxyURL
 
 	authInfo := kubecmdapi.NewAuthInfo()
 
@@ -46,31 +86,81 @@ func KubeConfig(input Params) {
 	case ClientCertType:
 		authInfo.ClientCertificateData = []byte(input.ClientCert)
 		authInfo.ClientKeyData = []byte(input.ClientKey)
+	case ImpersonateType:
+		authInfo.Token = input.Token
+		authInfo.Impersonate = input.Username
+		authInfo.ImpersonateGroups = input.GroupSlice()
 	default:
 		authInfo.Token = input.Token
 	}
 
+	clusterName := input.OutputClusterName()
+	contextName := input.OutputContextName()
+	userName := input.OutputUserName()
+
 	kubeContext := kubecmdapi.NewContext()
-	kubeContext.Cluster = input.ClusterName
-	kubeContext.AuthInfo = input.Username
+	kubeContext.Cluster = clusterName
+	kubeContext.AuthInfo = userName
 
-	kubecfg.Contexts[input.ClusterName] = kubeContext
 	kubecfg.APIVersion = "v1"
 	kubecfg.Kind = "Config"
-	kubecfg.Clusters[input.ClusterName] = cluster
-	kubecfg.AuthInfos[input.Username] = authInfo
-	kubecfg.CurrentContext = input.ClusterName
+	kubecfg.Clusters[clusterName] = cluster
+	kubecfg.AuthInfos[userName] = authInfo
+
+	// users with namespace scope get a context per namespace, so they do not
+	// start in the default namespace they have no access to
+	if nsContexts := input.OutputNamespaceContexts(); len(nsContexts) > 0 {
+		for _, ns := range input.NamespaceSlice() {
+			nsContext := kubeContext.DeepCopy()
+			nsContext.Namespace = ns
+			kubecfg.Contexts[nsContexts[ns]] = nsContext
+		}
+		kubecfg.CurrentContext = nsContexts[input.NamespaceSlice()[0]]
+		return kubecfg
+	}
+
+	kubecfg.Contexts[contextName] = kubeContext
+	kubecfg.CurrentContext = contextName
+
+	return kubecfg
+}
+
+// KubeConfig writes the kubeconfig of the user to its file, or merges it into
+// input.MergeInto. An existing file is only replaced with input.Overwrite.
+func KubeConfig(input Params) error {
+	kubecfg := BuildKubeConfig(input)
+
+	if input.MergeInto != "" {
+		if err := MergeKubeConfig(input.MergeInto, kubecfg, input.mergeOptions()); err != nil {
+			return fmt.Errorf("merge kubeconfig into %s err: %w", input.MergeInto, err)
+		}
+		log.Infof("generate kubeconfig for user '%s' success, merged into %s", input.Username, input.MergeInto)
+		return nil
+	}
 
-	c, err := kubecmd.Write(*kubecfg)
+	if input.secretOnly() {
+		// the command delivers the kubeconfig as a secret
+		return nil
+	}
+
+	if input.Output == StdoutOutput {
+		if err := WriteKubeConfig(input.stdout(), input); err != nil {
+			return fmt.Errorf("write kubeconfig to stdout err: %w", err)
+		}
+		log.Infof("generate kubeconfig for user '%s' success, written to stdout", input.Username)
+		return nil
+	}
+
+	data, err := kubecmd.Write(*kubecfg)
 	if err != nil {
-		return
+		return fmt.Errorf("serialize kubeconfig err: %w", err)
 	}
-	filename := input.SaveAsFile()
 
-	if err := ioutil.WriteFile(filename, c, 0644); err != nil {
-		log.Errorf("write kubeconfig file err: %v", err)
-		return
+	filename := input.SaveAsFile()
+	if err := writeKubeConfigFile(input.ConsoleOut(), filename, data); err != nil {
+		return fmt.Errorf("write kubeconfig file err: %w", err)
 	}
 
-	log.Infof("generate kubeconfig for user '%s' success, save as ./%s", input.Username, filename)
+	log.Infof("generate kubeconfig for user '%s' success, save as %s", input.Username, filename)
+	return nil
 }
@@ -0,0 +1,102 @@
+package generate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	kubecmd "k8s.io/client-go/tools/clientcmd"
+	kubecmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func Test_GetClusterName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := kubecmdapi.NewConfig()
+	cfg.Clusters["prod-cluster"] = kubecmdapi.NewCluster()
+	cfg.Clusters["staging-cluster"] = kubecmdapi.NewCluster()
+	cfg.Contexts["admin@prod"] = &kubecmdapi.Context{Cluster: "prod-cluster", AuthInfo: "admin"}
+	cfg.Contexts["admin@staging"] = &kubecmdapi.Context{Cluster: "staging-cluster", AuthInfo: "admin"}
+	cfg.CurrentContext = "admin@prod"
+
+	filename := filepath.Join(dir, "config")
+	if err := kubecmd.WriteToFile(*cfg, filename); err != nil {
+		t.Fatal(err)
+	}
+
+	if name, err := GetClusterName(filename, "", ""); err != nil || name != "prod-cluster" {
+		t.Errorf("want prod-cluster but got %q, err: %v", name, err)
+	}
+	if name, err := GetClusterName(filename, "admin@staging", ""); err != nil || name != "staging-cluster" {
+		t.Errorf("want staging-cluster but got %q, err: %v", name, err)
+	}
+	if _, err := GetClusterName(filename, "admin@dev", ""); err == nil {
+		t.Errorf("want err for unknown context but got nil")
+	}
+	if name, err := GetClusterName(filename, "admin@prod", "staging-cluster"); err != nil || name != "staging-cluster" {
+		t.Errorf("want the overridden staging-cluster but got %q, err: %v", name, err)
+	}
+	if _, err := GetClusterName(filename, "", "dev-cluster"); err == nil {
+		t.Errorf("want err for unknown cluster but got nil")
+	}
+}
+
+func Test_BuildKubeConfigNames(t *testing.T) {
+	p := Params{ClusterName: "prod", Username: "alice"}
+
+	cfg := BuildKubeConfig(p)
+	if cfg.CurrentContext != "prod" || cfg.Contexts["prod"].AuthInfo != "alice" {
+		t.Errorf("want context named after the cluster but got %+v", cfg.Contexts)
+	}
+
+	p.OutputCluster, p.OutputContext, p.OutputUser = "c", "ctx", "u"
+	cfg = BuildKubeConfig(p)
+	ctx := cfg.Contexts["ctx"]
+	if ctx == nil || ctx.Cluster != "c" || ctx.AuthInfo != "u" || cfg.Clusters["c"] == nil || cfg.AuthInfos["u"] == nil {
+		t.Errorf("want overridden names but got %+v", cfg)
+	}
+}
+
+func Test_BuildKubeConfigNamespaceContexts(t *testing.T) {
+	p := Params{ClusterName: "prod", Username: "alice", Scope: NamespaceScope, Namespaces: "payments,search"}
+
+	cfg := BuildKubeConfig(p)
+	if len(cfg.Contexts) != 2 || cfg.CurrentContext != "prod-payments" {
+		t.Fatalf("want a context per namespace but got %v, current %s", cfg.Contexts, cfg.CurrentContext)
+	}
+	if ctx := cfg.Contexts["prod-search"]; ctx == nil || ctx.Namespace != "search" || ctx.AuthInfo != "alice" {
+		t.Errorf("want context of search namespace but got %+v", ctx)
+	}
+
+	p.OutputContext = "{{.Namespace}}@{{.Cluster}}"
+	cfg = BuildKubeConfig(p)
+	if ctx := cfg.Contexts["payments@prod"]; ctx == nil || ctx.Namespace != "payments" {
+		t.Errorf("want templated context name but got %v", cfg.Contexts)
+	}
+}
+
+func Test_BuildKubeConfigCluster(t *testing.T) {
+	p := Params{ClusterName: "prod", Username: "alice", ClusterEndpoint: "https://127.0.0.1:6443", ClusterCA: "ca"}
+
+	cluster := BuildKubeConfig(p).Clusters["prod"]
+	if cluster.Server != "https://127.0.0.1:6443" || string(cluster.CertificateAuthorityData) != "ca" {
+		t.Errorf("want the endpoint and embedded CA of the operator but got %+v", cluster)
+	}
+
+	p.Server = "https://k8s.example.com:6443"
+	p.TLSServerName = "kubernetes.default"
+	p.ProxyURL = "socks5://localhost:1080"
+	p.CAFileRef = "/etc/kubernetes/ca.crt"
+	cluster = BuildKubeConfig(p).Clusters["prod"]
+	if cluster.Server != p.Server || cluster.TLSServerName != p.TLSServerName || cluster.ProxyURL != p.ProxyURL {
+		t.Errorf("want overridden connection options but got %+v", cluster)
+	}
+	if cluster.CertificateAuthority != p.CAFileRef || len(cluster.CertificateAuthorityData) != 0 {
+		t.Errorf("want a CA file reference without data but got %+v", cluster)
+	}
+}
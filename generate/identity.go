@@ -0,0 +1,68 @@
+package generate
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/cloudflare/cfssl/log"
+)
+
+// reservedUsernames are built-in privileged identities outside the system: prefix.
+var reservedUsernames = []string{"kubernetes-admin", "kube-apiserver-kubelet-client"}
+
+// ReservedIdentity returns why the username or groups are reserved for
+// built-in identities, issuing them is a silent grant RBAC can not revoke.
+// The names are trimmed first, " system:masters" is still system:masters.
+func ReservedIdentity(username string, groups []string) []string {
+	var reasons []string
+	username = strings.TrimSpace(username)
+
+	switch {
+	case strings.HasPrefix(username, "system:node:"):
+		reasons = append(reasons, fmt.Sprintf("username %q is a node identity", username))
+	case strings.HasPrefix(username, "system:"):
+		reasons = append(reasons, fmt.Sprintf("username %q has the reserved system: prefix", username))
+	case contains(reservedUsernames, username):
+		reasons = append(reasons, fmt.Sprintf("username %q is a built-in admin identity", username))
+	}
+
+	for _, g := range groups {
+		g = strings.TrimSpace(g)
+		switch {
+		case g == "system:masters":
+			reasons = append(reasons, "group system:masters bypasses RBAC entirely")
+		case strings.HasPrefix(g, "system:"):
+			reasons = append(reasons, fmt.Sprintf("group %q has the reserved system: prefix", g))
+		}
+	}
+
+	return reasons
+}
+
+// CheckIdentity refuses reserved identities unless p.AllowReservedIdentity is
+// set and the username is typed again to confirm.
+func CheckIdentity(p *Params) {
+	reasons := ReservedIdentity(p.Username, p.GroupSlice())
+	if len(reasons) == 0 {
+		return
+	}
+
+	for _, r := range reasons {
+		log.Warningf("reserved identity: %s", r)
+	}
+	if !p.AllowReservedIdentity {
+		log.Fatalf("refuse to issue a reserved identity, use -allow-reserved-identity to override")
+	}
+
+	var confirm string
+	prompt := &survey.Input{
+		Message: fmt.Sprintf("Type the username '%s' again to issue this reserved identity:", p.Username),
+	}
+	if err := survey.AskOne(prompt, &confirm, p.AskOpt()); err != nil {
+		log.Fatalf("got questions answers err: %v", err)
+	}
+	if confirm != p.Username {
+		log.Fatalf("username is not confirmed, nothing is created")
+	}
+}
@@ -0,0 +1,26 @@
+package generate
+
+import "testing"
+
+func Test_ReservedIdentity(t *testing.T) {
+	cases := []struct {
+		username string
+		groups   []string
+		reserved bool
+	}{
+		{username: "alice", groups: []string{"dev"}},
+		{username: "system:node:worker-1", reserved: true},
+		{username: "system:kube-scheduler", reserved: true},
+		{username: "kubernetes-admin", reserved: true},
+		{username: "alice", groups: []string{"system:masters"}, reserved: true},
+		{username: " system:admin ", reserved: true},
+		{username: "alice", groups: []string{"dev", " system:masters "}, reserved: true},
+		{username: "alice", groups: []string{"\tsystem:authenticated"}, reserved: true},
+	}
+
+	for _, tc := range cases {
+		if got := len(ReservedIdentity(tc.username, tc.groups)) > 0; got != tc.reserved {
+			t.Errorf("%q %q: want reserved %v but got %v", tc.username, tc.groups, tc.reserved, got)
+		}
+	}
+}
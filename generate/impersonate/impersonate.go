@@ -0,0 +1,107 @@
+package impersonate
+
+import (
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/cloudflare/cfssl/log"
+	"github.com/yahaa/gen-kubecfg/generate"
+)
+
+const (
+	defaultGateway          = "gen-kubecfg-gateway"
+	defaultGatewayNamespace = "kube-system"
+)
+
+// impersonateKubeconfig authenticates as a shared gateway service account and
+// acts as the real user, so RBAC still applies per person.
+type impersonateKubeconfig struct {
+	client generate.Client
+}
+
+func New(c generate.Client) generate.Generator {
+	return &impersonateKubeconfig{
+		client: c,
+	}
+}
+
+func (g *impersonateKubeconfig) Generate(p *generate.Params) {
+	if err := generate.KubeConfig(*p); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
+
+func (g *impersonateKubeconfig) ParseParams(p *generate.Params) {
+	var commonQ = []*survey.Question{
+		{
+			Name:     "username",
+			Prompt:   &survey.Input{Message: "Please input username which you want to generate kubeconfig for:"},
+			Validate: survey.Required,
+		},
+		{
+			Name: "groups",
+			Prompt: &survey.Input{
+				Message: "Please input groups of the user, split by ',' (optional):",
+			},
+		},
+		{
+			Name: "gateway",
+			Prompt: &survey.Input{
+				Message: "Please input name of the shared gateway service account:",
+				Default: defaultGateway,
+			},
+			Validate: survey.Required,
+		},
+		{
+			Name: "serviceAccountNamespace",
+			Prompt: &survey.Input{
+				Message: "Please input namespace of the gateway service account:",
+				Default: defaultGatewayNamespace,
+			},
+			Validate: generate.NamespaceValidator(&g.client, p),
+		},
+		{
+			Name: "saveAs",
+			Prompt: &survey.Input{
+				Message: "Please input kubeconfig save as name(default 'username.kubeconfig'):",
+			},
+		},
+	}
+	if err := survey.Ask(generate.OmitSaveAs(commonQ, *p), p, p.AskOpt()); err != nil {
+		log.Fatalf("got questions answers err: %v", err)
+	}
+
+	generate.CheckIdentity(p)
+
+	generate.AskScope(&g.client, p)
+}
+
+func (g *impersonateKubeconfig) PreGenerate(p *generate.Params) {
+	if err := g.client.EnsureNamespace(p.ServiceAccountNamespace, *p); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if err := g.client.CreateSAIfNotExist(p.ServiceAccountNamespace, p.Gateway); err != nil {
+		log.Fatalf("create gateway service account err: %v", err)
+	}
+
+	if err := g.client.ReCreateImpersonatorRole(p.Username, p.GroupSlice(), p.Gateway, p.ServiceAccountNamespace); err != nil {
+		log.Fatalf("create impersonator role err: %v", err)
+	}
+	log.Infof("allow %s/%s to impersonate user '%s' success", p.ServiceAccountNamespace, p.Gateway, p.Username)
+
+	token, err := g.client.GetServiceAccountToken(p.ServiceAccountNamespace, p.Gateway, p.Lifetime)
+	if err != nil {
+		log.Fatalf("got gateway service account token err: %v", err)
+	}
+
+	p.Token = token
+}
+
+func (g *impersonateKubeconfig) PostGenerate(p *generate.Params) {
+	if err := g.client.ApplyCustomRole(p); err != nil {
+		log.Fatalf("apply custom role err: %v", err)
+	}
+
+	if err := g.client.GenerateBinding("User", *p); err != nil {
+		log.Fatalf("generate binding err: %v", err)
+	}
+}
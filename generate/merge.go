@@ -0,0 +1,273 @@
+package generate
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cfssl/log"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	kubecmd "k8s.io/client-go/tools/clientcmd"
+	kubecmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Policies of name collisions when merging a kubeconfig.
+const (
+	ConflictReject    = "reject"
+	ConflictRename    = "rename"
+	ConflictOverwrite = "overwrite"
+)
+
+type MergeOptions struct {
+	// OnConflict is one of ConflictReject, ConflictRename and ConflictOverwrite,
+	// an entry equal to the existing one is never a conflict
+	OnConflict string
+	// SetCurrentContext switches the current-context of the target to the merged one
+	SetCurrentContext bool
+	// Identity is the subject the merged user is issued for, see subjectString.
+	// An existing user entry of the same identity is re-issued, it is replaced
+	// instead of being a conflict.
+	Identity string
+}
+
+func (p Params) mergeOptions() MergeOptions {
+	return MergeOptions{
+		OnConflict:        p.OnConflict,
+		SetCurrentContext: p.SetCurrentContext,
+		Identity:          subjectString(p.subject()),
+	}
+}
+
+// CheckMerge runs the conflict check of MergeKubeConfig without writing
+// anything, so a conflict is found before the credentials are issued.
+func CheckMerge(target string, kubecfg *kubecmdapi.Config, opts MergeOptions) error {
+	existing, _, err := loadMergeTarget(target)
+	if err != nil {
+		return err
+	}
+	_, err = mergeConfig(existing, kubecfg, opts)
+	return err
+}
+
+func loadMergeTarget(target string) (*kubecmdapi.Config, []byte, error) {
+	data, err := ioutil.ReadFile(target)
+	switch {
+	case err == nil:
+		existing, err := kubecmd.Load(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("load kubeconfig %s err: %w", target, err)
+		}
+		return existing, data, nil
+	case os.IsNotExist(err):
+		return kubecmdapi.NewConfig(), nil, nil
+	default:
+		return nil, nil, err
+	}
+}
+
+// MergeKubeConfig merges the clusters, users and contexts of kubecfg into the
+// kubeconfig file target, which is created if it does not exist. The original
+// file is kept as a backup next to it.
+func MergeKubeConfig(target string, kubecfg *kubecmdapi.Config, opts MergeOptions) error {
+	existing, data, err := loadMergeTarget(target)
+	if err != nil {
+		return err
+	}
+
+	merged, err := mergeConfig(existing, kubecfg, opts)
+	if err != nil {
+		return err
+	}
+
+	if data != nil {
+		backup := fmt.Sprintf("%s.bak-%s", target, time.Now().Format("20060102150405"))
+		if err := ioutil.WriteFile(backup, data, 0600); err != nil {
+			return fmt.Errorf("backup kubeconfig %s err: %w", target, err)
+		}
+		log.Infof("backup %s as %s", target, backup)
+	}
+
+	content, err := kubecmd.Write(*merged)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+	return WriteFileAtomic(target, content, 0600)
+}
+
+func mergeConfig(existing, kubecfg *kubecmdapi.Config, opts MergeOptions) (*kubecmdapi.Config, error) {
+	switch opts.OnConflict {
+	case ConflictReject, ConflictRename, ConflictOverwrite:
+	case "":
+		opts.OnConflict = ConflictReject
+	default:
+		return nil, fmt.Errorf("unknown conflict policy %q", opts.OnConflict)
+	}
+
+	clusterNames := make(map[string]string)
+	for name, c := range kubecfg.Clusters {
+		newName, err := mergeName("cluster", name, opts.OnConflict, func(n string) (bool, bool) {
+			old, ok := existing.Clusters[n]
+			return ok, ok && equalCluster(old, c)
+		})
+		if err != nil {
+			return nil, err
+		}
+		existing.Clusters[newName] = c
+		clusterNames[name] = newName
+	}
+
+	userNames := make(map[string]string)
+	for name, u := range kubecfg.AuthInfos {
+		newName, err := mergeName("user", name, opts.OnConflict, func(n string) (bool, bool) {
+			old, ok := existing.AuthInfos[n]
+			return ok, ok && (equalAuthInfo(old, u) || reissues(existing, n, old, opts.Identity, clusterNames))
+		})
+		if err != nil {
+			return nil, err
+		}
+		existing.AuthInfos[newName] = u
+		userNames[name] = newName
+	}
+
+	contextNames := make(map[string]string)
+	for name, ctx := range kubecfg.Contexts {
+		merged := ctx.DeepCopy()
+		merged.Cluster = clusterNames[ctx.Cluster]
+		merged.AuthInfo = userNames[ctx.AuthInfo]
+
+		newName, err := mergeName("context", name, opts.OnConflict, func(n string) (bool, bool) {
+			old, ok := existing.Contexts[n]
+			return ok, ok && equalContext(old, merged)
+		})
+		if err != nil {
+			return nil, err
+		}
+		existing.Contexts[newName] = merged
+		contextNames[name] = newName
+	}
+
+	if opts.SetCurrentContext || existing.CurrentContext == "" {
+		existing.CurrentContext = contextNames[kubecfg.CurrentContext]
+	}
+
+	return existing, nil
+}
+
+// mergeName returns the name of an entry in the merged kubeconfig, lookup tells
+// whether a name is taken and whether the entry there equals the new one.
+func mergeName(kind, name, onConflict string, lookup func(string) (taken, equal bool)) (string, error) {
+	taken, equal := lookup(name)
+	if !taken || equal || onConflict == ConflictOverwrite {
+		return name, nil
+	}
+
+	if onConflict == ConflictReject {
+		return "", fmt.Errorf("%s %q already exists with different content, choose another conflict policy", kind, name)
+	}
+
+	for i := 2; ; i++ {
+		newName := fmt.Sprintf("%s-%d", name, i)
+		if taken, equal := lookup(newName); !taken || equal {
+			log.Infof("%s %q already exists, renamed to %q", kind, name, newName)
+			return newName, nil
+		}
+	}
+}
+
+// reissues tells if the existing user entry name is issued for identity and
+// only used with the merged clusters, so replacing it re-issues the user of
+// this cluster without touching the contexts of other clusters.
+func reissues(existing *kubecmdapi.Config, name string, old *kubecmdapi.AuthInfo, identity string, clusterNames map[string]string) bool {
+	if identity == "" || authInfoIdentity(old) != identity {
+		return false
+	}
+	for _, ctx := range existing.Contexts {
+		if ctx.AuthInfo != name {
+			continue
+		}
+		merged := false
+		for _, c := range clusterNames {
+			if ctx.Cluster == c {
+				merged = true
+			}
+		}
+		if !merged {
+			return false
+		}
+	}
+	return true
+}
+
+// authInfoIdentity tells the subject a user entry was issued for from its
+// credentials, like subjectString: the impersonated user, the common name of
+// the client certificate or the subject of a service account token. It is
+// empty if the credentials do not tell.
+func authInfoIdentity(a *kubecmdapi.AuthInfo) string {
+	switch {
+	case a.Impersonate != "":
+		return rbacv1.UserKind + "/" + a.Impersonate
+	case len(a.ClientCertificateData) > 0:
+		block, _ := pem.Decode(a.ClientCertificateData)
+		if block == nil {
+			return ""
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil || cert.Subject.CommonName == "" {
+			return ""
+		}
+		return rbacv1.UserKind + "/" + cert.Subject.CommonName
+	case a.Token != "":
+		parts := strings.Split(a.Token, ".")
+		if len(parts) != 3 {
+			return ""
+		}
+		payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			return ""
+		}
+		var claims struct {
+			Sub string `json:"sub"`
+		}
+		if err := json.Unmarshal(payload, &claims); err != nil {
+			return ""
+		}
+		// system:serviceaccount:<namespace>:<name>
+		sa := strings.Split(claims.Sub, ":")
+		if len(sa) != 4 || sa[0] != "system" || sa[1] != "serviceaccount" {
+			return ""
+		}
+		return rbacv1.ServiceAccountKind + "/" + sa[2] + "/" + sa[3]
+	}
+	return ""
+}
+
+// equalCluster, equalAuthInfo and equalContext compare kubeconfig entries
+// ignoring where they were loaded from.
+func equalCluster(a, b *kubecmdapi.Cluster) bool {
+	x, y := *a, *b
+	x.LocationOfOrigin, y.LocationOfOrigin = "", ""
+	return equality.Semantic.DeepEqual(x, y)
+}
+
+func equalAuthInfo(a, b *kubecmdapi.AuthInfo) bool {
+	x, y := *a, *b
+	x.LocationOfOrigin, y.LocationOfOrigin = "", ""
+	return equality.Semantic.DeepEqual(x, y)
+}
+
+func equalContext(a, b *kubecmdapi.Context) bool {
+	x, y := *a, *b
+	x.LocationOfOrigin, y.LocationOfOrigin = "", ""
+	return equality.Semantic.DeepEqual(x, y)
+}
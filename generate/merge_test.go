@@ -0,0 +1,116 @@
+package generate
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	kubecmd "k8s.io/client-go/tools/clientcmd"
+)
+
+func Test_MergeKubeConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "config")
+	staging := Params{ClusterName: "staging", ClusterEndpoint: "https://staging:6443", Username: "alice", Token: "t1"}
+
+	if err := MergeKubeConfig(target, BuildKubeConfig(staging), MergeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// merging the same entries again is not a conflict
+	if err := MergeKubeConfig(target, BuildKubeConfig(staging), MergeOptions{}); err != nil {
+		t.Fatalf("want nil for equal entries but got %v", err)
+	}
+
+	other := staging
+	other.Token = "t2"
+	if err := MergeKubeConfig(target, BuildKubeConfig(other), MergeOptions{OnConflict: ConflictReject}); err == nil {
+		t.Fatalf("want err for conflicting user but got nil")
+	}
+
+	if err := MergeKubeConfig(target, BuildKubeConfig(other), MergeOptions{OnConflict: ConflictRename, SetCurrentContext: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := kubecmd.LoadFromFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.AuthInfos["alice"].Token != "t1" || cfg.AuthInfos["alice-2"].Token != "t2" {
+		t.Errorf("want renamed user but got %v", cfg.AuthInfos)
+	}
+	if ctx := cfg.Contexts[cfg.CurrentContext]; ctx == nil || ctx.AuthInfo != "alice-2" {
+		t.Errorf("want current context using the renamed user but got %v", ctx)
+	}
+
+	backups, _ := filepath.Glob(target + ".bak-*")
+	if len(backups) == 0 {
+		t.Errorf("want backup of the merged kubeconfig")
+	}
+}
+
+func Test_CheckMergeReissue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "config")
+	jwt := func(sub string) string {
+		return "e30." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"`+sub+`"}`)) + ".sig"
+	}
+	staging := Params{
+		Type:                    TokenType,
+		ClusterName:             "staging",
+		ClusterEndpoint:         "https://staging:6443",
+		Username:                "deployer",
+		ServiceAccountNamespace: "ci",
+		Token:                   jwt("system:serviceaccount:ci:deployer"),
+		MergeInto:               target,
+	}
+	if err := MergeKubeConfig(target, BuildKubeConfig(staging), staging.mergeOptions()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the credentials are not issued yet when the output is checked
+	reissue := staging
+	reissue.Token = ""
+	if err := reissue.CheckOutput(ioutil.Discard); err != nil {
+		t.Errorf("want re-issuing the same service account allowed but got %v", err)
+	}
+
+	other := reissue
+	other.ServiceAccountNamespace = "prod"
+	if err := other.CheckOutput(ioutil.Discard); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("want a conflict for a user of another service account but got %v", err)
+	}
+
+	// the user entry is still used by the staging context
+	prod := reissue
+	prod.ClusterName = "prod"
+	prod.ClusterEndpoint = "https://prod:6443"
+	if err := prod.CheckOutput(ioutil.Discard); err == nil {
+		t.Errorf("want a conflict for the user of another cluster but got nil")
+	}
+
+	reissue.Token = jwt("system:serviceaccount:ci:deployer") + "2"
+	if err := MergeKubeConfig(target, BuildKubeConfig(reissue), reissue.mergeOptions()); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := kubecmd.LoadFromFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.AuthInfos) != 1 || cfg.AuthInfos["deployer"] == nil || cfg.AuthInfos["deployer"].Token != reissue.Token {
+		t.Errorf("want the user replaced by the re-issued one but got %v", cfg.AuthInfos)
+	}
+}
@@ -0,0 +1,250 @@
+package generate
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/cloudflare/cfssl/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GetNamespaceNames returns the names of the namespaces matching the label selector.
+func (kt *Client) GetNamespaceNames(selector string) ([]string, error) {
+	namespaces, err := kt.client.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, item := range namespaces.Items {
+		names = append(names, item.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ResolveNamespaces adds the live namespaces matching p.NamespaceSelector and
+// the globs of p.NamespaceGlob to p.Namespaces.
+func (kt *Client) ResolveNamespaces(p *Params) error {
+	if p.NamespaceSelector == "" && p.NamespaceGlob == "" {
+		return nil
+	}
+
+	names, err := kt.GetNamespaceNames(p.NamespaceSelector)
+	if err != nil {
+		return fmt.Errorf("list namespaces err: %w", err)
+	}
+
+	matched, err := matchGlobs(names, p.NamespaceGlob)
+	if err != nil {
+		return err
+	}
+
+	if len(matched) == 0 {
+		return fmt.Errorf("not found any namespace matching selector %q and glob %q", p.NamespaceSelector, p.NamespaceGlob)
+	}
+
+	if p.Namespaces != "" {
+		matched = append([]string{p.Namespaces}, matched...)
+	}
+	p.Namespaces = strings.Join(matched, ",")
+
+	return nil
+}
+
+// matchGlobs returns the names matching any of the globs split by ',', all the
+// names are returned if there is no glob.
+func matchGlobs(names []string, globs string) ([]string, error) {
+	var patterns []string
+	for _, g := range strings.Split(globs, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			patterns = append(patterns, g)
+		}
+	}
+
+	if len(patterns) == 0 {
+		return names, nil
+	}
+
+	var res []string
+	for _, name := range names {
+		for _, pattern := range patterns {
+			ok, err := path.Match(pattern, name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid namespace glob %q: %w", pattern, err)
+			}
+			if ok {
+				res = append(res, name)
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+// NamespaceNotFoundError is returned for a missing namespace when namespace
+// creation is not requested, it suggests the closest existing namespace.
+type NamespaceNotFoundError struct {
+	Namespace  string
+	Suggestion string
+}
+
+func (e *NamespaceNotFoundError) Error() string {
+	msg := fmt.Sprintf("namespace %q not found", e.Namespace)
+	if e.Suggestion != "" {
+		msg += fmt.Sprintf(", did you mean %q?", e.Suggestion)
+	}
+	return msg + " (use -create-namespaces to create it)"
+}
+
+func (kt *Client) namespaceNotFound(namespace string) error {
+	names, _ := kt.GetNamespaceNames("")
+	return &NamespaceNotFoundError{
+		Namespace:  namespace,
+		Suggestion: closestName(namespace, names),
+	}
+}
+
+// createsNamespace tells if namespace may be created: with
+// p.CreateNamespaces any namespace may, with p.Onboard only the onboarded
+// ones, so a typo in the service account or secret namespace is still caught.
+func (p Params) createsNamespace(namespace string) bool {
+	if p.CreateNamespaces {
+		return true
+	}
+	if !p.Onboard {
+		return false
+	}
+	for _, ns := range p.NamespaceSlice() {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// namespaceExists tells if namespace exists. An operator who may not get
+// namespaces can still hold permissions inside of them, the namespace is
+// taken as existing then and the namespaced operations tell if it is not.
+func (kt *Client) namespaceExists(namespace string) (bool, error) {
+	_, err := kt.client.CoreV1().Namespaces().Get(context.TODO(), namespace, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		return true, nil
+	case apierrors.IsNotFound(err):
+		return false, nil
+	case apierrors.IsForbidden(err):
+		log.Warningf("can not verify that namespace %s exists: %v", namespace, err)
+		return true, nil
+	}
+	return false, err
+}
+
+// CheckNamespace returns a NamespaceNotFoundError if namespace does not exist
+// and p does not ask for creating it.
+func (kt *Client) CheckNamespace(namespace string, p Params) error {
+	exists, err := kt.namespaceExists(namespace)
+	if err != nil || exists || p.createsNamespace(namespace) {
+		return err
+	}
+	return kt.namespaceNotFound(namespace)
+}
+
+// EnsureNamespace creates namespace with the labels and annotations of p if it
+// does not exist, which is only allowed when p asks for creating it.
+func (kt *Client) EnsureNamespace(namespace string, p Params) error {
+	exists, err := kt.namespaceExists(namespace)
+	if err != nil || exists {
+		return err
+	}
+
+	if !p.createsNamespace(namespace) {
+		return kt.namespaceNotFound(namespace)
+	}
+
+	labels, err := ParseKeyValues(p.NamespaceLabels)
+	if err != nil {
+		return fmt.Errorf("parse namespace labels err: %w", err)
+	}
+	annotations, err := ParseKeyValues(p.NamespaceAnnotations)
+	if err != nil {
+		return fmt.Errorf("parse namespace annotations err: %w", err)
+	}
+
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        namespace,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+	}
+
+	if _, err := kt.client.CoreV1().Namespaces().Create(context.TODO(), ns, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("create namespace %s err: %w", namespace, err)
+	}
+	log.Infof("create namespace %s success", namespace)
+
+	return nil
+}
+
+// ParseKeyValues parses "k1=v1,k2=v2" into a map.
+func ParseKeyValues(s string) (map[string]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	res := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" {
+			return nil, fmt.Errorf("invalid key value pair %q", kv)
+		}
+		res[key] = strings.TrimSpace(parts[1])
+	}
+	return res, nil
+}
+
+// closestName returns the candidate with the smallest edit distance to name,
+// or "" if none of them is close enough to be a typo of it.
+func closestName(name string, candidates []string) string {
+	best, bestDist := "", len(name)/3+2
+	for _, c := range candidates {
+		if d := editDistance(name, c); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j] + 1
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+			if prev[j-1]+cost < cur[j] {
+				cur[j] = prev[j-1] + cost
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,104 @@
+package generate
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_matchGlobs(t *testing.T) {
+	names := []string{"default", "payments-api", "payments-db", "search"}
+
+	got, err := matchGlobs(names, "payments-*, search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []string{"payments-api", "payments-db", "search"}) {
+		t.Errorf("unexpected namespaces: %v", got)
+	}
+
+	got, err = matchGlobs(names, "")
+	if err != nil || !reflect.DeepEqual(got, names) {
+		t.Errorf("want all namespaces but got %v, err: %v", got, err)
+	}
+
+	if _, err := matchGlobs(names, "[a-"); err == nil {
+		t.Errorf("want err for invalid glob but got nil")
+	}
+}
+
+func Test_closestName(t *testing.T) {
+	names := []string{"default", "payments", "search"}
+
+	if got := closestName("paymnets", names); got != "payments" {
+		t.Errorf("want payments but got %q", got)
+	}
+
+	if got := closestName("monitoring", names); got != "" {
+		t.Errorf("want no suggestion but got %q", got)
+	}
+}
+
+func Test_EnsureNamespace(t *testing.T) {
+	c := NewClient(fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments"}}))
+
+	err := c.EnsureNamespace("paymnets", Params{})
+	nsErr, ok := err.(*NamespaceNotFoundError)
+	if !ok || nsErr.Suggestion != "payments" {
+		t.Fatalf("want NamespaceNotFoundError with suggestion but got %v", err)
+	}
+
+	p := Params{CreateNamespaces: true, NamespaceLabels: "team=search"}
+	if err := c.EnsureNamespace("search", p); err != nil {
+		t.Fatal(err)
+	}
+
+	ns, err := c.ClientSet().CoreV1().Namespaces().Get(context.TODO(), "search", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns.Labels["team"] != "search" {
+		t.Errorf("want labels of created namespace but got %v", ns.Labels)
+	}
+}
+
+func Test_EnsureNamespaceForbidden(t *testing.T) {
+	cs := fake.NewSimpleClientset()
+	cs.PrependReactor("get", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(corev1.Resource("namespaces"), "payments", nil)
+	})
+	c := NewClient(cs)
+
+	if err := c.CheckNamespace("payments", Params{}); err != nil {
+		t.Errorf("want a forbidden namespace taken as existing but got %v", err)
+	}
+	if err := c.EnsureNamespace("payments", Params{CreateNamespaces: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range cs.Actions() {
+		if action.GetVerb() == "create" {
+			t.Errorf("want no namespace created but got %v", action)
+		}
+	}
+}
+
+func Test_createsNamespace(t *testing.T) {
+	p := Params{Onboard: true, Namespaces: "payments,search"}
+	if !p.createsNamespace("search") {
+		t.Error("want an onboarded namespace created")
+	}
+	if p.createsNamespace("serach") {
+		t.Error("want no namespace created outside of the onboarded ones")
+	}
+	p.CreateNamespaces = true
+	if !p.createsNamespace("serach") {
+		t.Error("want any namespace created with CreateNamespaces")
+	}
+}
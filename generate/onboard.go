@@ -0,0 +1,304 @@
+package generate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/cloudflare/cfssl/log"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	onboardQuotaName         = "gen-kubecfg-quota"
+	onboardLimitRangeName    = "gen-kubecfg-limits"
+	onboardNetworkPolicyName = "default-deny"
+
+	podSecurityLabelPrefix = "pod-security.kubernetes.io/"
+
+	// the egress of onboarded pods is limited to the cluster DNS, the name
+	// label is set on every namespace by kubernetes 1.21+
+	namespaceNameLabel = "kubernetes.io/metadata.name"
+	clusterDNSLabel    = "k8s-app"
+	clusterDNSApp      = "kube-dns"
+)
+
+// PodSecurityLevels are the levels of Pod Security admission.
+var PodSecurityLevels = []string{"privileged", "baseline", "restricted"}
+
+// QuotaPresets are the named ResourceQuotas of onboarded namespaces.
+var QuotaPresets = map[string]corev1.ResourceList{
+	"small": {
+		corev1.ResourceRequestsCPU:            resource.MustParse("2"),
+		corev1.ResourceRequestsMemory:         resource.MustParse("4Gi"),
+		corev1.ResourceLimitsCPU:              resource.MustParse("4"),
+		corev1.ResourceLimitsMemory:           resource.MustParse("8Gi"),
+		corev1.ResourcePods:                   resource.MustParse("20"),
+		corev1.ResourcePersistentVolumeClaims: resource.MustParse("5"),
+	},
+	"medium": {
+		corev1.ResourceRequestsCPU:            resource.MustParse("8"),
+		corev1.ResourceRequestsMemory:         resource.MustParse("16Gi"),
+		corev1.ResourceLimitsCPU:              resource.MustParse("16"),
+		corev1.ResourceLimitsMemory:           resource.MustParse("32Gi"),
+		corev1.ResourcePods:                   resource.MustParse("50"),
+		corev1.ResourcePersistentVolumeClaims: resource.MustParse("10"),
+	},
+	"large": {
+		corev1.ResourceRequestsCPU:            resource.MustParse("32"),
+		corev1.ResourceRequestsMemory:         resource.MustParse("64Gi"),
+		corev1.ResourceLimitsCPU:              resource.MustParse("64"),
+		corev1.ResourceLimitsMemory:           resource.MustParse("128Gi"),
+		corev1.ResourcePods:                   resource.MustParse("200"),
+		corev1.ResourcePersistentVolumeClaims: resource.MustParse("30"),
+	},
+}
+
+// LimitRangePresets are the named container defaults of onboarded namespaces.
+var LimitRangePresets = map[string]corev1.LimitRangeItem{
+	"small": {
+		Type: corev1.LimitTypeContainer,
+		Default: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("500m"),
+			corev1.ResourceMemory: resource.MustParse("512Mi"),
+		},
+		DefaultRequest: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("100m"),
+			corev1.ResourceMemory: resource.MustParse("128Mi"),
+		},
+	},
+	"medium": {
+		Type: corev1.LimitTypeContainer,
+		Default: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("1"),
+			corev1.ResourceMemory: resource.MustParse("1Gi"),
+		},
+		DefaultRequest: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("250m"),
+			corev1.ResourceMemory: resource.MustParse("256Mi"),
+		},
+	},
+	"large": {
+		Type: corev1.LimitTypeContainer,
+		Default: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("2"),
+			corev1.ResourceMemory: resource.MustParse("2Gi"),
+		},
+		DefaultRequest: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("500m"),
+			corev1.ResourceMemory: resource.MustParse("512Mi"),
+		},
+	},
+}
+
+// ValidateOnboard checks the presets and the pod security level of p.
+func (p Params) ValidateOnboard() error {
+	if _, ok := QuotaPresets[p.QuotaPreset]; !ok {
+		return fmt.Errorf("unknown quota preset %q", p.QuotaPreset)
+	}
+	if _, ok := LimitRangePresets[p.LimitPreset]; !ok {
+		return fmt.Errorf("unknown limit range preset %q", p.LimitPreset)
+	}
+	for _, level := range PodSecurityLevels {
+		if level == p.PodSecurity {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown pod security level %q", p.PodSecurity)
+}
+
+// CheckOnboard refuses to onboard namespaces which already exist, onboarding
+// relabels them and replaces their quota, limit range and network policy. They
+// are onboarded if p.Force is set or the operator confirms it.
+func (kt *Client) CheckOnboard(p Params) error {
+	var existing []string
+	for _, ns := range p.NamespaceSlice() {
+		exists, err := kt.namespaceExists(ns)
+		if err != nil {
+			return fmt.Errorf("get namespace %s err: %w", ns, err)
+		}
+		if exists {
+			existing = append(existing, ns)
+		}
+	}
+	if len(existing) == 0 {
+		return nil
+	}
+
+	if p.Force {
+		log.Warningf("onboard existing namespaces %s", strings.Join(existing, ", "))
+		return nil
+	}
+	ok, err := confirmOnboardExisting(existing, p.AskOpt())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("namespaces %s already exist, they are not onboarded", strings.Join(existing, ", "))
+	}
+	return nil
+}
+
+// confirmOnboardExisting asks whether to onboard the existing namespaces.
+var confirmOnboardExisting = func(namespaces []string, opt survey.AskOpt) (bool, error) {
+	ok := false
+	prompt := &survey.Confirm{
+		Message: fmt.Sprintf("Namespaces %s already exist, replace their pod security labels, quota, limit range and network policy?",
+			strings.Join(namespaces, ", ")),
+		Default: false,
+	}
+	err := survey.AskOne(prompt, &ok, opt)
+	return ok, err
+}
+
+// Onboard sets up every namespace of p as a tenant namespace: it is created
+// (or relabelled) with the Pod Security admission level, and gets a
+// ResourceQuota, a LimitRange and a default-deny NetworkPolicy. The bindings
+// of the user are generated by GenerateBinding as usual.
+func (kt *Client) Onboard(p Params) error {
+	if err := p.ValidateOnboard(); err != nil {
+		return err
+	}
+
+	p.Onboard = true
+	for _, ns := range p.NamespaceSlice() {
+		if err := kt.EnsureNamespace(ns, p); err != nil {
+			return err
+		}
+		if err := kt.labelPodSecurity(ns, p.PodSecurity); err != nil {
+			return fmt.Errorf("label namespace %s err: %w", ns, err)
+		}
+		if err := kt.applyResourceQuota(ns, QuotaPresets[p.QuotaPreset]); err != nil {
+			return fmt.Errorf("apply resource quota in %s namespace err: %w", ns, err)
+		}
+		if err := kt.applyLimitRange(ns, LimitRangePresets[p.LimitPreset]); err != nil {
+			return fmt.Errorf("apply limit range in %s namespace err: %w", ns, err)
+		}
+		if err := kt.applyDefaultDeny(ns); err != nil {
+			return fmt.Errorf("apply network policy in %s namespace err: %w", ns, err)
+		}
+		log.Infof("onboard namespace %s success (pod security: %s, quota: %s, limits: %s)",
+			ns, p.PodSecurity, p.QuotaPreset, p.LimitPreset)
+	}
+
+	return nil
+}
+
+func (kt *Client) labelPodSecurity(namespace, level string) error {
+	ns, err := kt.client.CoreV1().Namespaces().Get(context.TODO(), namespace, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	if ns.Labels == nil {
+		ns.Labels = make(map[string]string)
+	}
+	for _, mode := range []string{"enforce", "audit", "warn"} {
+		ns.Labels[podSecurityLabelPrefix+mode] = level
+	}
+
+	_, err = kt.client.CoreV1().Namespaces().Update(context.TODO(), ns, metav1.UpdateOptions{})
+	return err
+}
+
+func (kt *Client) applyResourceQuota(namespace string, hard corev1.ResourceList) error {
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      onboardQuotaName,
+			Namespace: namespace,
+		},
+		Spec: corev1.ResourceQuotaSpec{
+			Hard: hard,
+		},
+	}
+
+	quotas := kt.client.CoreV1().ResourceQuotas(namespace)
+	old, err := quotas.Get(context.TODO(), quota.Name, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		quota.ResourceVersion = old.ResourceVersion
+		_, err = quotas.Update(context.TODO(), quota, metav1.UpdateOptions{})
+	case apierrors.IsNotFound(err):
+		_, err = quotas.Create(context.TODO(), quota, metav1.CreateOptions{})
+	}
+
+	return err
+}
+
+func (kt *Client) applyLimitRange(namespace string, item corev1.LimitRangeItem) error {
+	lr := &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      onboardLimitRangeName,
+			Namespace: namespace,
+		},
+		Spec: corev1.LimitRangeSpec{
+			Limits: []corev1.LimitRangeItem{item},
+		},
+	}
+
+	limitRanges := kt.client.CoreV1().LimitRanges(namespace)
+	old, err := limitRanges.Get(context.TODO(), lr.Name, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		lr.ResourceVersion = old.ResourceVersion
+		_, err = limitRanges.Update(context.TODO(), lr, metav1.UpdateOptions{})
+	case apierrors.IsNotFound(err):
+		_, err = limitRanges.Create(context.TODO(), lr, metav1.CreateOptions{})
+	}
+
+	return err
+}
+
+// applyDefaultDeny denies all ingress and egress traffic of the pods in
+// namespace except DNS lookups to the cluster DNS pods in kube-system.
+func (kt *Client) applyDefaultDeny(namespace string) error {
+	udp, tcp := corev1.ProtocolUDP, corev1.ProtocolTCP
+	dns := intstr.FromInt(53)
+
+	np := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      onboardNetworkPolicyName,
+			Namespace: namespace,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			Egress: []networkingv1.NetworkPolicyEgressRule{
+				{
+					To: []networkingv1.NetworkPolicyPeer{
+						{
+							NamespaceSelector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{namespaceNameLabel: metav1.NamespaceSystem},
+							},
+							PodSelector: &metav1.LabelSelector{
+								MatchLabels: map[string]string{clusterDNSLabel: clusterDNSApp},
+							},
+						},
+					},
+					Ports: []networkingv1.NetworkPolicyPort{
+						{Protocol: &udp, Port: &dns},
+						{Protocol: &tcp, Port: &dns},
+					},
+				},
+			},
+		},
+	}
+
+	policies := kt.client.NetworkingV1().NetworkPolicies(namespace)
+	old, err := policies.Get(context.TODO(), np.Name, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		np.ResourceVersion = old.ResourceVersion
+		_, err = policies.Update(context.TODO(), np, metav1.UpdateOptions{})
+	case apierrors.IsNotFound(err):
+		_, err = policies.Create(context.TODO(), np, metav1.CreateOptions{})
+	}
+
+	return err
+}
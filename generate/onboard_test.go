@@ -0,0 +1,91 @@
+package generate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/AlecAivazis/survey/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_Onboard(t *testing.T) {
+	c := NewClient(fake.NewSimpleClientset())
+	p := Params{
+		Namespaces:  "payments",
+		QuotaPreset: "small",
+		LimitPreset: "medium",
+		PodSecurity: "baseline",
+	}
+
+	if err := c.Onboard(p); err != nil {
+		t.Fatal(err)
+	}
+
+	cs := c.ClientSet()
+	ns, err := cs.CoreV1().Namespaces().Get(context.TODO(), "payments", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ns.Labels["pod-security.kubernetes.io/enforce"] != "baseline" {
+		t.Errorf("want pod security label but got %v", ns.Labels)
+	}
+
+	if _, err := cs.CoreV1().ResourceQuotas("payments").Get(context.TODO(), onboardQuotaName, metav1.GetOptions{}); err != nil {
+		t.Errorf("want resource quota but got err: %v", err)
+	}
+	if _, err := cs.CoreV1().LimitRanges("payments").Get(context.TODO(), onboardLimitRangeName, metav1.GetOptions{}); err != nil {
+		t.Errorf("want limit range but got err: %v", err)
+	}
+	np, err := cs.NetworkingV1().NetworkPolicies("payments").Get(context.TODO(), onboardNetworkPolicyName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("want network policy but got err: %v", err)
+	}
+	if to := np.Spec.Egress[0].To; len(to) != 1 || to[0].PodSelector == nil || to[0].PodSelector.MatchLabels["k8s-app"] != "kube-dns" ||
+		to[0].NamespaceSelector == nil || to[0].NamespaceSelector.MatchLabels["kubernetes.io/metadata.name"] != "kube-system" {
+		t.Errorf("want the DNS egress limited to the cluster DNS pods but got %+v", to)
+	}
+
+	p.QuotaPreset = "huge"
+	if err := c.Onboard(p); err == nil {
+		t.Errorf("want err for unknown preset but got nil")
+	}
+}
+
+func Test_CheckOnboard(t *testing.T) {
+	c := NewClient(fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "legacy"}}))
+
+	confirm := confirmOnboardExisting
+	defer func() { confirmOnboardExisting = confirm }()
+	var asked []string
+	confirmOnboardExisting = func(namespaces []string, _ survey.AskOpt) (bool, error) {
+		asked = namespaces
+		return false, nil
+	}
+
+	p := Params{Namespaces: "payments"}
+	if err := c.CheckOnboard(p); err != nil || asked != nil {
+		t.Errorf("want a new namespace onboarded without asking but got %v, asked %v", err, asked)
+	}
+
+	p.Namespaces = "payments,legacy"
+	if err := c.CheckOnboard(p); err == nil || len(asked) != 1 || asked[0] != "legacy" {
+		t.Errorf("want an existing namespace refused unless confirmed but got %v, asked %v", err, asked)
+	}
+
+	confirmOnboardExisting = func([]string, survey.AskOpt) (bool, error) { return true, nil }
+	if err := c.CheckOnboard(p); err != nil {
+		t.Errorf("want a confirmed namespace onboarded but got %v", err)
+	}
+
+	asked = nil
+	confirmOnboardExisting = func(namespaces []string, _ survey.AskOpt) (bool, error) {
+		asked = namespaces
+		return false, nil
+	}
+	p.Force = true
+	if err := c.CheckOnboard(p); err != nil || asked != nil {
+		t.Errorf("want existing namespaces onboarded with force but got %v, asked %v", err, asked)
+	}
+}
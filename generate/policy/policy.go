@@ -0,0 +1,216 @@
+package policy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/yahaa/gen-kubecfg/generate"
+)
+
+// DefaultFile is the policy of the host, it is enforced whenever it exists,
+// whether or not -policy is given.
+const DefaultFile = "/etc/gen-kubecfg/policy.yaml"
+
+// Policy constrains who can be granted what, it is loaded from DefaultFile and
+// the file given by -policy and enforced before anything is created. An empty field does not
+// constrain anything, e.g. all cluster roles are allowed without clusterRoles.
+type Policy struct {
+	// ClusterRoles are the cluster roles which may be granted
+	ClusterRoles []Grant `json:"clusterRoles,omitempty"`
+	// Roles are the namespaced roles which may be granted
+	Roles []Grant `json:"roles,omitempty"`
+	// AllowCustomRoles allows to author custom roles for the user
+	AllowCustomRoles bool `json:"allowCustomRoles,omitempty"`
+	// Usernames constrains the usernames (service account names for token type)
+	Usernames Names `json:"usernames,omitempty"`
+	// Groups constrains the groups of the issued identity, e.g. system:masters
+	// may be reserved
+	Groups Names `json:"groups,omitempty"`
+	// MaxLifetime is the max lifetime of issued certificates and tokens, e.g.
+	// 720h. A client certificate the signer issues for longer is refused.
+	MaxLifetime string `json:"maxLifetime,omitempty"`
+
+	maxLifetime time.Duration
+}
+
+// Grant allows a role at some scopes in some namespaces.
+type Grant struct {
+	Name string `json:"name"`
+	// Scopes are "cluster" and/or "namespace", any scope if empty
+	Scopes []string `json:"scopes,omitempty"`
+	// Namespaces are globs of the namespaces for namespace scope, any namespace if empty
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// Names constrains the usernames or groups of the issued identity.
+type Names struct {
+	// Allowed are globs of the allowed names, any name if empty
+	Allowed []string `json:"allowed,omitempty"`
+	// Reserved are globs of the names which may never be issued, e.g. system:*
+	Reserved []string `json:"reserved,omitempty"`
+}
+
+func Load(filename string) (*Policy, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Policy
+	if err := yaml.UnmarshalStrict(data, &p); err != nil {
+		return nil, fmt.Errorf("parse policy %s err: %w", filename, err)
+	}
+
+	if p.MaxLifetime != "" {
+		if p.maxLifetime, err = time.ParseDuration(p.MaxLifetime); err != nil {
+			return nil, fmt.Errorf("parse maxLifetime of policy %s err: %w", filename, err)
+		}
+	}
+
+	return &p, nil
+}
+
+// LoadDefault loads DefaultFile, it returns nil if the file does not exist.
+func LoadDefault() (*Policy, error) {
+	pol, err := Load(DefaultFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return pol, err
+}
+
+// Violation explains why the request breaks the policy.
+type Violation string
+
+// Error lists all the violations of the policy.
+type Error []Violation
+
+func (e Error) Error() string {
+	var b strings.Builder
+	b.WriteString("the request is not allowed by the issuance policy:")
+	for _, v := range e {
+		b.WriteString("\n  - ")
+		b.WriteString(string(v))
+	}
+	return b.String()
+}
+
+// Check returns an Error with all the violations of the policy. The token
+// lifetime of p defaults to the max lifetime of the policy, which also limits
+// the client certificate, see generate.Params.CertLifetime.
+func (pol *Policy) Check(p *generate.Params) error {
+	var vs []Violation
+	add := func(format string, args ...interface{}) {
+		vs = append(vs, Violation(fmt.Sprintf(format, args...)))
+	}
+
+	vs = append(vs, pol.Usernames.check("username", p.Username)...)
+	for _, group := range p.GroupSlice() {
+		vs = append(vs, pol.Groups.check("group", group)...)
+	}
+
+	namespaces := p.NamespaceSlice()
+	for _, cr := range p.ClusterRoles {
+		for _, v := range checkGrant(pol.ClusterRoles, "cluster role", cr, p.Scope, namespaces) {
+			vs = append(vs, v)
+		}
+	}
+	for _, r := range p.Roles {
+		for _, v := range checkGrant(pol.Roles, "role", r, p.Scope, namespaces) {
+			vs = append(vs, v)
+		}
+	}
+
+	if len(p.CustomRules) > 0 && !pol.AllowCustomRoles {
+		add("custom roles are not allowed")
+	}
+
+	if pol.maxLifetime > 0 {
+		switch p.Type {
+		case generate.TokenType, generate.ImpersonateType:
+			if p.Lifetime == 0 {
+				p.Lifetime = pol.maxLifetime
+			}
+		}
+		if p.MaxLifetime == 0 || pol.maxLifetime < p.MaxLifetime {
+			p.MaxLifetime = pol.maxLifetime
+		}
+		if p.Lifetime > pol.maxLifetime {
+			add("lifetime %s is longer than the max lifetime %s", p.Lifetime, pol.maxLifetime)
+		}
+	}
+
+	if len(vs) > 0 {
+		return Error(vs)
+	}
+	return nil
+}
+
+func (n Names) check(kind, name string) []Violation {
+	var vs []Violation
+	if pattern, ok := matchAny(n.Reserved, name); ok {
+		vs = append(vs, Violation(fmt.Sprintf("%s %q is reserved (matches %q)", kind, name, pattern)))
+	}
+	if len(n.Allowed) > 0 {
+		if _, ok := matchAny(n.Allowed, name); !ok {
+			vs = append(vs, Violation(fmt.Sprintf("%s %q does not match any allowed pattern %v", kind, name, n.Allowed)))
+		}
+	}
+	return vs
+}
+
+func checkGrant(grants []Grant, kind, name, scope string, namespaces []string) []Violation {
+	if len(grants) == 0 {
+		return nil
+	}
+
+	var g *Grant
+	for i := range grants {
+		if grants[i].Name == name {
+			g = &grants[i]
+			break
+		}
+	}
+	if g == nil {
+		return []Violation{Violation(fmt.Sprintf("%s %q may not be granted", kind, name))}
+	}
+
+	if len(g.Scopes) > 0 {
+		allowed := false
+		for _, s := range g.Scopes {
+			if s == scope {
+				allowed = true
+			}
+		}
+		if !allowed {
+			return []Violation{Violation(fmt.Sprintf("%s %q may only be granted at %v scope, not %s scope", kind, name, g.Scopes, scope))}
+		}
+	}
+
+	if scope != generate.NamespaceScope || len(g.Namespaces) == 0 {
+		return nil
+	}
+
+	var vs []Violation
+	for _, ns := range namespaces {
+		if _, ok := matchAny(g.Namespaces, ns); !ok {
+			vs = append(vs, Violation(fmt.Sprintf("%s %q may not be granted in namespace %q, allowed namespaces: %v", kind, name, ns, g.Namespaces)))
+		}
+	}
+	return vs
+}
+
+func matchAny(patterns []string, name string) (string, bool) {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return pattern, true
+		}
+	}
+	return "", false
+}
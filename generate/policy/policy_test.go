@@ -0,0 +1,90 @@
+package policy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yahaa/gen-kubecfg/generate"
+)
+
+const testPolicy = `
+clusterRoles:
+- name: view
+- name: edit
+  scopes: [namespace]
+  namespaces: ["payments-*"]
+usernames:
+  allowed: ["*@example.com"]
+  reserved: ["system:*", "admin@example.com"]
+groups:
+  reserved: ["system:masters"]
+maxLifetime: 720h
+`
+
+func Test_Check(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "policy.yaml")
+	if err := ioutil.WriteFile(filename, []byte(testPolicy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pol, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &generate.Params{
+		Type:         generate.TokenType,
+		Username:     "alice@example.com",
+		Scope:        generate.NamespaceScope,
+		Namespaces:   "payments-api",
+		ClusterRoles: []string{"view", "edit"},
+	}
+	if err := pol.Check(p); err != nil {
+		t.Fatalf("want nil but got %v", err)
+	}
+	if p.Lifetime != 720*time.Hour {
+		t.Errorf("want lifetime defaults to max lifetime but got %s", p.Lifetime)
+	}
+
+	p = &generate.Params{
+		Type:         generate.ClientCertType,
+		Username:     "bob@example.com",
+		Groups:       "dev",
+		Scope:        generate.ClusterScope,
+		ClusterRoles: []string{"view"},
+	}
+	if err := pol.Check(p); err != nil {
+		t.Fatalf("want nil but got %v", err)
+	}
+	if p.Lifetime != 0 || p.CertLifetime() != 720*time.Hour {
+		t.Errorf("want the certificate limited by the max lifetime but got lifetime %s and limit %s", p.Lifetime, p.CertLifetime())
+	}
+
+	p = &generate.Params{
+		Username:     "admin@example.com",
+		Groups:       "dev,system:masters",
+		Scope:        generate.ClusterScope,
+		ClusterRoles: []string{"edit", "cluster-admin"},
+		Lifetime:     1000 * time.Hour,
+	}
+	err = pol.Check(p)
+	perr, ok := err.(Error)
+	if !ok || len(perr) != 5 {
+		t.Fatalf("want 5 violations but got %v", err)
+	}
+	for _, want := range []string{"username \"admin@example.com\" is reserved", "group \"system:masters\" is reserved", "only be granted at [namespace] scope", "\"cluster-admin\" may not be granted", "longer than the max lifetime"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("want %q in %v", want, err)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package generate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	certv1beta1 "k8s.io/api/certificates/v1beta1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PreflightCheck is an access the operator needs on the chosen path. A failed
+// check which is not Required is reported as a warning.
+type PreflightCheck struct {
+	Description string
+	Attributes  authorizationv1.ResourceAttributes
+	Required    bool
+	// Rules are the permissions granted by a bind check, it passes too if the
+	// operator holds all of them.
+	Rules []rbacv1.PolicyRule
+}
+
+type PreflightResult struct {
+	PreflightCheck
+	Allowed bool
+	// HoldsRules is set for a bind check passed by holding all of its rules.
+	HoldsRules bool
+	Err        error
+}
+
+func requiredCheck(desc string, attr authorizationv1.ResourceAttributes) PreflightCheck {
+	return PreflightCheck{Description: desc, Attributes: attr, Required: true}
+}
+
+// accessChecks returns the required checks of verbs on the resource in
+// namespace, it is cluster-wide if namespace is empty.
+func accessChecks(group, resource, namespace string, verbs ...string) []PreflightCheck {
+	checks := make([]PreflightCheck, 0, len(verbs))
+	for _, verb := range verbs {
+		desc := fmt.Sprintf("%s %s", verb, resource)
+		if namespace != "" {
+			desc += " in " + namespace
+		}
+		checks = append(checks, requiredCheck(desc, authorizationv1.ResourceAttributes{
+			Namespace: namespace, Verb: verb, Group: group, Resource: resource,
+		}))
+	}
+	return checks
+}
+
+// TypeChecks returns the accesses which only depend on the type of p and the
+// CA source, they run as soon as the type is known, before any question about
+// the user is asked: the CSRs or the service accounts of the type, and the
+// reads of the CA and endpoint discovery.
+func TypeChecks(p Params, caSource string) []PreflightCheck {
+	var checks []PreflightCheck
+
+	// without a CA source of its own, auto takes the first readable one
+	if p.CAFileRef == "" {
+		rootCA := authorizationv1.ResourceAttributes{
+			Namespace: metav1.NamespaceDefault, Verb: "get", Resource: "configmaps", Name: rootCAConfigMap,
+		}
+		clusterInfo := authorizationv1.ResourceAttributes{
+			Namespace: metav1.NamespacePublic, Verb: "get", Resource: "configmaps", Name: "cluster-info",
+		}
+		switch caSource {
+		case CASourceRootCA:
+			checks = append(checks, requiredCheck("read the root CA config map", rootCA))
+		case CASourceClusterInfo:
+			checks = append(checks, requiredCheck("read the cluster-info config map", clusterInfo))
+		case CASourceAuto:
+			checks = append(checks,
+				PreflightCheck{Description: "read the root CA config map", Attributes: rootCA},
+				PreflightCheck{Description: "read the cluster-info config map", Attributes: clusterInfo},
+			)
+		}
+	}
+
+	// endpoint discovery tolerates every source it can not read
+	if p.Server == "" || p.Server == ServerAuto {
+		checks = append(checks,
+			PreflightCheck{Description: "read the kubernetes endpoints", Attributes: authorizationv1.ResourceAttributes{
+				Namespace: metav1.NamespaceDefault, Verb: "get", Resource: "endpoints", Name: "kubernetes",
+			}},
+			PreflightCheck{Description: "list services", Attributes: authorizationv1.ResourceAttributes{
+				Verb: "list", Resource: "services",
+			}},
+			PreflightCheck{Description: "list ingresses", Attributes: authorizationv1.ResourceAttributes{
+				Verb: "list", Group: networkingv1.GroupName, Resource: "ingresses",
+			}},
+		)
+	}
+
+	switch p.Type {
+	case ClientCertType:
+		checks = append(checks,
+			requiredCheck("create CSRs", authorizationv1.ResourceAttributes{
+				Verb: "create", Group: certv1beta1.GroupName, Resource: "certificatesigningrequests",
+			}),
+			requiredCheck("delete CSRs", authorizationv1.ResourceAttributes{
+				Verb: "delete", Group: certv1beta1.GroupName, Resource: "certificatesigningrequests",
+			}),
+			requiredCheck("approve CSRs", authorizationv1.ResourceAttributes{
+				Verb: "update", Group: certv1beta1.GroupName, Resource: "certificatesigningrequests", Subresource: "approval",
+			}),
+			// signer permissions are only enforced by kubernetes 1.18+
+			PreflightCheck{
+				Description: "approve with the legacy-unknown signer",
+				Attributes: authorizationv1.ResourceAttributes{
+					Verb: "approve", Group: certv1beta1.GroupName, Resource: "signers", Name: "kubernetes.io/legacy-unknown",
+				},
+			},
+		)
+	case TokenType:
+		checks = append(checks, accessChecks("", "serviceaccounts", p.ServiceAccountNamespace, "get")...)
+	case ImpersonateType:
+		checks = append(checks, accessChecks("", "serviceaccounts", p.ServiceAccountNamespace, "get", "create")...)
+		checks = append(checks, accessChecks(rbacv1.GroupName, "clusterroles", "", "get", "create", "update")...)
+		checks = append(checks, accessChecks(rbacv1.GroupName, "clusterrolebindings", "", "get", "create", "update")...)
+	}
+
+	return checks
+}
+
+// PreflightChecks returns the accesses needed to generate the kubeconfig of
+// p besides the TypeChecks, it runs once the answers are known: bindings and custom roles are
+// checked in the namespaces of p, or cluster-wide without namespaces.
+func PreflightChecks(p Params) []PreflightCheck {
+	var checks []PreflightCheck
+	namespaces := p.NamespaceSlice()
+
+	// a binding whose role ref does not match is only deleted with -force
+	bindingVerbs := []string{"get", "create", "update"}
+	if p.Force {
+		bindingVerbs = append(bindingVerbs, "delete")
+	}
+	if len(p.ClusterRoles) > 0 || len(p.Roles) > 0 || len(p.CustomRules) > 0 {
+		if len(namespaces) == 0 {
+			checks = append(checks, accessChecks(rbacv1.GroupName, "clusterrolebindings", "", bindingVerbs...)...)
+		}
+		for _, ns := range namespaces {
+			checks = append(checks, accessChecks(rbacv1.GroupName, "rolebindings", ns, bindingVerbs...)...)
+		}
+	}
+
+	if len(p.CustomRules) > 0 {
+		if len(namespaces) == 0 {
+			checks = append(checks, accessChecks(rbacv1.GroupName, "clusterroles", "", "get", "create", "update")...)
+		}
+		for _, ns := range namespaces {
+			checks = append(checks, accessChecks(rbacv1.GroupName, "roles", ns, "get", "create", "update")...)
+		}
+	}
+
+	// only missing namespaces are created
+	if p.CreateNamespaces || p.Onboard {
+		checks = append(checks, PreflightCheck{
+			Description: "create namespaces",
+			Attributes:  authorizationv1.ResourceAttributes{Verb: "create", Resource: "namespaces"},
+		})
+	}
+
+	if p.Onboard {
+		for _, ns := range namespaces {
+			checks = append(checks, requiredCheck(fmt.Sprintf("label namespace %s", ns), authorizationv1.ResourceAttributes{
+				Verb: "update", Resource: "namespaces", Name: ns,
+			}))
+			checks = append(checks, accessChecks("", "resourcequotas", ns, "get", "create", "update")...)
+			checks = append(checks, accessChecks("", "limitranges", ns, "get", "create", "update")...)
+			checks = append(checks, accessChecks(networkingv1.GroupName, "networkpolicies", ns, "get", "create", "update")...)
+		}
+	}
+
+	// the service account is only known once the username is answered
+	if p.Type == TokenType || p.Type == ImpersonateType {
+		ns := p.ServiceAccountNamespace
+		if p.Type == TokenType && !p.ExistedSA {
+			checks = append(checks, accessChecks("", "serviceaccounts", ns, "list", "create")...)
+		}
+		if p.Lifetime > 0 {
+			checks = append(checks, requiredCheck("create service account tokens in "+ns, authorizationv1.ResourceAttributes{
+				Namespace: ns, Verb: "create", Resource: "serviceaccounts", Subresource: "token",
+			}))
+		} else {
+			checks = append(checks, requiredCheck("read service account token secrets in "+ns, authorizationv1.ResourceAttributes{
+				Namespace: ns, Verb: "get", Resource: "secrets",
+			}))
+		}
+	}
+
+	return checks
+}
+
+// BindChecks returns the escalation checks of binding the roles of p, the
+// custom role and the impersonator role. A role can be bound with the bind
+// verb, or by an operator holding all of its permissions, which Preflight
+// tells by a SelfSubjectRulesReview. Creating the custom and impersonator
+// roles needs the same permissions.
+func (kt *Client) BindChecks(p Params) ([]PreflightCheck, error) {
+	var checks []PreflightCheck
+	namespaces := p.NamespaceSlice()
+	add := func(desc, namespace, resource, name string, rules []rbacv1.PolicyRule) {
+		if namespace != "" {
+			desc += " in " + namespace
+		}
+		checks = append(checks, PreflightCheck{
+			Description: desc,
+			Attributes: authorizationv1.ResourceAttributes{
+				Namespace: namespace, Verb: "bind", Group: rbacv1.GroupName, Resource: resource, Name: name,
+			},
+			Required: true,
+			Rules:    rules,
+		})
+	}
+
+	for _, cr := range p.ClusterRoles {
+		rules, err := kt.ClusterRoleRules(cr)
+		if err != nil {
+			return nil, fmt.Errorf("get rules of cluster role %s err: %w", cr, err)
+		}
+		if len(namespaces) == 0 {
+			add("bind cluster role "+cr, "", "clusterroles", cr, rules)
+		}
+		for _, ns := range namespaces {
+			add("bind cluster role "+cr, ns, "clusterroles", cr, rules)
+		}
+	}
+
+	for _, r := range p.Roles {
+		for _, ns := range namespaces {
+			// a role missing in the namespace is not bound there, see planBindings
+			role, err := kt.client.RbacV1().Roles(ns).Get(context.TODO(), r, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("get role %s in %s namespace err: %w", r, ns, err)
+			}
+			add("bind role "+r, ns, "roles", r, role.Rules)
+		}
+	}
+
+	if len(p.CustomRules) > 0 {
+		name := CustomRoleName(p.subject())
+		if len(namespaces) == 0 {
+			add("bind custom cluster role "+name, "", "clusterroles", name, p.CustomRules)
+		}
+		for _, ns := range namespaces {
+			add("bind custom role "+name, ns, "roles", name, p.CustomRules)
+		}
+	}
+
+	if p.Type == ImpersonateType {
+		name := ImpersonatorRoleName(p.Username)
+		add("bind impersonator role "+name, "", "clusterroles", name, impersonatorRules(p.Username, p.GroupSlice()))
+	}
+
+	return checks, nil
+}
+
+// Preflight reviews the checks as the operator with SelfSubjectAccessReviews.
+func (kt *Client) Preflight(checks []PreflightCheck) []PreflightResult {
+	results := make([]PreflightResult, 0, len(checks))
+	for _, c := range checks {
+		attr := c.Attributes
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: &attr},
+		}
+
+		res := PreflightResult{PreflightCheck: c}
+		status, err := kt.client.AuthorizationV1().SelfSubjectAccessReviews().Create(context.TODO(), review, metav1.CreateOptions{})
+		if err != nil {
+			res.Err = err
+		} else {
+			res.Allowed = status.Status.Allowed
+		}
+		if res.Err == nil && !res.Allowed && len(c.Rules) > 0 {
+			res.Allowed, res.Err = kt.holdsRules(attr.Namespace, c.Rules)
+			res.HoldsRules = res.Allowed
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// PrintPreflight writes the pass/fail table of results, it returns false if
+// any required check failed.
+func PrintPreflight(w io.Writer, results []PreflightResult) bool {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	ok := true
+	fmt.Fprintln(tw, "CHECK\tRESULT")
+	for _, r := range results {
+		status := "pass"
+		switch {
+		case r.HoldsRules:
+			status = "pass (holds all the permissions)"
+		case r.Allowed:
+		case r.Required:
+			status = "FAIL"
+			ok = false
+		default:
+			status = "warn"
+		}
+		if r.Err != nil {
+			status += fmt.Sprintf(" (%v)", r.Err)
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", r.Description, status)
+	}
+	return ok
+}
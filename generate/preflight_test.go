@@ -0,0 +1,150 @@
+package generate
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_PrintPreflight(t *testing.T) {
+	results := []PreflightResult{
+		{PreflightCheck: PreflightCheck{Description: "create CSRs", Required: true}, Allowed: true},
+		{PreflightCheck: PreflightCheck{Description: "bind cluster role edit"}},
+	}
+
+	var buf bytes.Buffer
+	if !PrintPreflight(&buf, results) {
+		t.Errorf("want ok without failed required checks")
+	}
+	if !strings.Contains(buf.String(), "warn") {
+		t.Errorf("want warn for optional check but got:\n%s", buf.String())
+	}
+
+	results = append(results, PreflightResult{PreflightCheck: PreflightCheck{Description: "delete CSRs", Required: true}})
+	buf.Reset()
+	if PrintPreflight(&buf, results) {
+		t.Errorf("want failed with a failed required check")
+	}
+	if !strings.Contains(buf.String(), "FAIL") {
+		t.Errorf("want FAIL in table but got:\n%s", buf.String())
+	}
+}
+
+func Test_PreflightChecks(t *testing.T) {
+	p := Params{Type: TokenType, ServiceAccountNamespace: "ci", Namespaces: "dev", ClusterRoles: []string{"edit"}}
+
+	got := make(map[string]bool)
+	for _, c := range PreflightChecks(p) {
+		got[c.Description] = true
+		if c.Required && c.Attributes.Resource != "namespaces" && c.Attributes.Namespace == "" {
+			t.Errorf("want namespaced check but got cluster-wide %q", c.Description)
+		}
+	}
+	for _, want := range []string{"create rolebindings in dev", "create serviceaccounts in ci", "read service account token secrets in ci"} {
+		if !got[want] {
+			t.Errorf("want check %q but got %v", want, got)
+		}
+	}
+	for _, unwanted := range []string{"create clusterrolebindings", "delete rolebindings in dev"} {
+		if got[unwanted] {
+			t.Errorf("want no check %q without cluster scope and -force", unwanted)
+		}
+	}
+
+	p.Force = true
+	p.Onboard = true
+	got = make(map[string]bool)
+	for _, c := range PreflightChecks(p) {
+		got[c.Description] = true
+	}
+	for _, want := range []string{"delete rolebindings in dev", "create namespaces", "label namespace dev", "create networkpolicies in dev"} {
+		if !got[want] {
+			t.Errorf("want check %q but got %v", want, got)
+		}
+	}
+}
+
+func Test_TypeChecks(t *testing.T) {
+	got := make(map[string]PreflightCheck)
+	for _, c := range TypeChecks(Params{Type: ClientCertType, Server: ServerAuto}, CASourceRootCA) {
+		got[c.Description] = c
+	}
+	for _, want := range []string{"create CSRs", "approve CSRs", "read the root CA config map", "list services"} {
+		if _, ok := got[want]; !ok {
+			t.Errorf("want check %q but got %v", want, got)
+		}
+	}
+	if !got["read the root CA config map"].Required || got["list services"].Required {
+		t.Errorf("want the chosen CA source required and the endpoint discovery optional but got %v", got)
+	}
+
+	got = make(map[string]PreflightCheck)
+	p := Params{Type: TokenType, ServiceAccountNamespace: "ci", Server: "https://k8s.example.com:6443", CAFileRef: "/etc/ca.crt"}
+	for _, c := range TypeChecks(p, CASourceAuto) {
+		got[c.Description] = c
+	}
+	if len(got) != 1 || !got["get serviceaccounts in ci"].Required {
+		t.Errorf("want only the service account check but got %v", got)
+	}
+}
+
+func Test_BindChecksSkipsMissingRole(t *testing.T) {
+	reader := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: "reader", Namespace: "dev"},
+		Rules:      []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}},
+	}
+	c := NewClient(fake.NewSimpleClientset(reader))
+
+	checks, err := c.BindChecks(Params{Namespaces: "dev,prod", Roles: []string{"reader"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 1 || checks[0].Attributes.Namespace != "dev" || len(checks[0].Rules) != 1 {
+		t.Errorf("want only the bind check of dev namespace but got %+v", checks)
+	}
+}
+
+func Test_BindChecksHoldsRules(t *testing.T) {
+	view := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: "view"},
+		Rules:      []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods", "pods/log"}, Verbs: []string{"get", "list"}}},
+	}
+	edit := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: "edit"},
+		Rules:      []rbacv1.PolicyRule{{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"update"}}},
+	}
+	cs := fake.NewSimpleClientset(view, edit)
+	cs.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &authorizationv1.SelfSubjectAccessReview{}, nil
+	})
+	cs.PrependReactor("create", "selfsubjectrulesreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, &authorizationv1.SelfSubjectRulesReview{Status: authorizationv1.SubjectRulesReviewStatus{
+			ResourceRules: []authorizationv1.ResourceRule{{APIGroups: []string{""}, Resources: []string{"pods", "pods/log"}, Verbs: []string{"*"}}},
+		}}, nil
+	})
+	c := NewClient(cs)
+
+	checks, err := c.BindChecks(Params{Namespaces: "dev", ClusterRoles: []string{"view", "edit"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := c.Preflight(checks)
+	if len(results) != 2 || !results[0].HoldsRules || results[1].Allowed || !results[1].Required {
+		t.Fatalf("want view held and edit failed but got %+v", results)
+	}
+	if results[0].Attributes.Namespace != "dev" {
+		t.Errorf("want the bind check in dev namespace but got %q", results[0].Attributes.Namespace)
+	}
+	var buf bytes.Buffer
+	if PrintPreflight(&buf, results) {
+		t.Errorf("want a failed bind check to fail the preflight but got:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,185 @@
+package generate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cloudflare/cfssl/log"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ResourceVerbs are the merged verbs granted on a resource.
+type ResourceVerbs struct {
+	// Resource is qualified by its api group like kubectl does, e.g. deployments.apps,
+	// or a non resource url like /healthz
+	Resource      string
+	ResourceNames []string
+	Verbs         []string
+}
+
+// ClusterRoleRules returns the rules of the cluster role, including the rules
+// of the cluster roles aggregated into it.
+func (kt *Client) ClusterRoleRules(name string) ([]rbacv1.PolicyRule, error) {
+	return kt.clusterRoleRules(name, make(map[string]bool))
+}
+
+func (kt *Client) clusterRoleRules(name string, visited map[string]bool) ([]rbacv1.PolicyRule, error) {
+	if visited[name] {
+		return nil, nil
+	}
+	visited[name] = true
+
+	cr, err := kt.client.RbacV1().ClusterRoles().Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	rules := append([]rbacv1.PolicyRule{}, cr.Rules...)
+	if cr.AggregationRule == nil {
+		return rules, nil
+	}
+
+	for _, ls := range cr.AggregationRule.ClusterRoleSelectors {
+		selector, err := metav1.LabelSelectorAsSelector(&ls)
+		if err != nil {
+			return nil, err
+		}
+		list, err := kt.client.RbacV1().ClusterRoles().List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range list.Items {
+			more, err := kt.clusterRoleRules(item.Name, visited)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, more...)
+		}
+	}
+
+	return rules, nil
+}
+
+// SelectedRules returns the rules of the cluster roles and roles chosen in p.
+func (kt *Client) SelectedRules(p Params) ([]rbacv1.PolicyRule, error) {
+	var rules []rbacv1.PolicyRule
+
+	for _, cr := range p.ClusterRoles {
+		more, err := kt.ClusterRoleRules(cr)
+		if err != nil {
+			return nil, fmt.Errorf("get rules of cluster role %s err: %w", cr, err)
+		}
+		rules = append(rules, more...)
+	}
+
+	for _, ns := range p.NamespaceSlice() {
+		for _, r := range p.Roles {
+			role, err := kt.client.RbacV1().Roles(ns).Get(context.TODO(), r, metav1.GetOptions{})
+			if err != nil {
+				continue
+			}
+			rules = append(rules, role.Rules...)
+		}
+	}
+
+	return rules, nil
+}
+
+// ClusterScopedResources returns the qualified names of the cluster scoped
+// resources, which are not granted by a role binding.
+func (kt *Client) ClusterScopedResources() map[string]bool {
+	lists, err := kt.client.Discovery().ServerPreferredResources()
+	if err != nil {
+		log.Warningf("discovery api resources err: %v", err)
+	}
+
+	res := make(map[string]bool)
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, r := range list.APIResources {
+			if !r.Namespaced {
+				res[qualifiedResource(gv.Group, r.Name)] = true
+			}
+		}
+	}
+	return res
+}
+
+// MergeRules merges the verbs of rules per resource. When namespaced is set,
+// the rules are granted in namespaces, so cluster scoped resources and non
+// resource urls are dropped.
+func MergeRules(rules []rbacv1.PolicyRule, clusterScoped map[string]bool, namespaced bool) []ResourceVerbs {
+	merged := make(map[string]map[string]bool)
+	names := make(map[string][]string)
+
+	add := func(key, resource string, resourceNames []string, verbs []string) {
+		if merged[key] == nil {
+			merged[key] = make(map[string]bool)
+			names[key] = append([]string{resource}, resourceNames...)
+		}
+		for _, v := range verbs {
+			merged[key][v] = true
+		}
+	}
+
+	for _, rule := range rules {
+		sortedNames := append([]string{}, rule.ResourceNames...)
+		sort.Strings(sortedNames)
+
+		for _, res := range qualifiedResources(rule.APIGroups, rule.Resources) {
+			if namespaced && clusterScoped[res] {
+				continue
+			}
+			add(res+"|"+strings.Join(sortedNames, ","), res, sortedNames, rule.Verbs)
+		}
+		if !namespaced {
+			for _, url := range rule.NonResourceURLs {
+				add(url+"|", url, nil, rule.Verbs)
+			}
+		}
+	}
+
+	res := make([]ResourceVerbs, 0, len(merged))
+	for key, verbs := range merged {
+		rv := ResourceVerbs{
+			Resource:      names[key][0],
+			ResourceNames: names[key][1:],
+		}
+		if verbs["*"] {
+			rv.Verbs = []string{"*"}
+		} else {
+			for v := range verbs {
+				rv.Verbs = append(rv.Verbs, v)
+			}
+			sort.Strings(rv.Verbs)
+		}
+		res = append(res, rv)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Resource != res[j].Resource {
+			return res[i].Resource < res[j].Resource
+		}
+		return strings.Join(res[i].ResourceNames, ",") < strings.Join(res[j].ResourceNames, ",")
+	})
+	return res
+}
+
+func PrintResourceVerbs(w io.Writer, merged []ResourceVerbs) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	fmt.Fprintln(tw, "RESOURCE\tVERBS\tNAMES")
+	for _, rv := range merged {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", rv.Resource, strings.Join(rv.Verbs, ","), strings.Join(rv.ResourceNames, ","))
+	}
+}
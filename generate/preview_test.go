@@ -0,0 +1,57 @@
+package generate
+
+import (
+	"reflect"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_MergeRules(t *testing.T) {
+	rules := []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"pods", "nodes"}, Verbs: []string{"get", "list"}},
+		{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"watch", "get"}},
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"*"}},
+		{NonResourceURLs: []string{"/healthz"}, Verbs: []string{"get"}},
+	}
+	clusterScoped := map[string]bool{"nodes": true}
+
+	want := []ResourceVerbs{
+		{Resource: "deployments.apps", ResourceNames: []string{}, Verbs: []string{"*"}},
+		{Resource: "pods", ResourceNames: []string{}, Verbs: []string{"get", "list", "watch"}},
+	}
+	if got := MergeRules(rules, clusterScoped, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("namespace scope: want %+v but got %+v", want, got)
+	}
+
+	if got := MergeRules(rules, clusterScoped, false); len(got) != 4 {
+		t.Errorf("cluster scope: want nodes and /healthz kept but got %+v", got)
+	}
+}
+
+func Test_ClusterRoleRulesAggregated(t *testing.T) {
+	c := NewClient(fake.NewSimpleClientset(
+		&rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: "monitoring"},
+			AggregationRule: &rbacv1.AggregationRule{
+				ClusterRoleSelectors: []metav1.LabelSelector{
+					{MatchLabels: map[string]string{"aggregate-to-monitoring": "true"}},
+				},
+			},
+		},
+		&rbacv1.ClusterRole{
+			ObjectMeta: metav1.ObjectMeta{Name: "metrics", Labels: map[string]string{"aggregate-to-monitoring": "true"}},
+			Rules:      []rbacv1.PolicyRule{{APIGroups: []string{"metrics.k8s.io"}, Resources: []string{"pods"}, Verbs: []string{"get"}}},
+		},
+	))
+
+	rules, err := c.ClusterRoleRules("monitoring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].APIGroups[0] != "metrics.k8s.io" {
+		t.Errorf("want aggregated rules but got %+v", rules)
+	}
+}
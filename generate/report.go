@@ -0,0 +1,160 @@
+package generate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	kubecmd "k8s.io/client-go/tools/clientcmd"
+)
+
+// clusterChecks are the cluster scoped access checks of the permission report.
+var clusterChecks = []authorizationv1.ResourceAttributes{
+	{Verb: "list", Resource: "namespaces"},
+	{Verb: "list", Resource: "nodes"},
+	{Verb: "list", Resource: "persistentvolumes"},
+	{Verb: "list", Resource: "pods"},
+	{Verb: "list", Resource: "secrets"},
+	{Verb: "create", Resource: "pods"},
+	{Verb: "list", Group: "rbac.authorization.k8s.io", Resource: "clusterroles"},
+	{Verb: "create", Group: "rbac.authorization.k8s.io", Resource: "clusterrolebindings"},
+	{Verb: "create", Group: "certificates.k8s.io", Resource: "certificatesigningrequests"},
+	{Verb: "list", Group: "apiextensions.k8s.io", Resource: "customresourcedefinitions"},
+}
+
+// AccessCheck is the result of a SelfSubjectAccessReview.
+type AccessCheck struct {
+	Verb     string `json:"verb"`
+	Group    string `json:"group,omitempty"`
+	Resource string `json:"resource"`
+	Allowed  bool   `json:"allowed"`
+}
+
+// NamespacePermissions is the result of a SelfSubjectRulesReview.
+type NamespacePermissions struct {
+	Namespace        string                            `json:"namespace"`
+	ResourceRules    []authorizationv1.ResourceRule    `json:"resourceRules"`
+	NonResourceRules []authorizationv1.NonResourceRule `json:"nonResourceRules,omitempty"`
+	Incomplete       bool                              `json:"incomplete,omitempty"`
+}
+
+// PermissionReport tells what the identity of a kubeconfig can do.
+type PermissionReport struct {
+	Namespaces []NamespacePermissions `json:"namespaces"`
+	Cluster    []AccessCheck          `json:"cluster"`
+}
+
+// BuildPermissionReport reviews the permissions of the identity in kubeconfig,
+// the rules in every namespace and a set of cluster scoped checks.
+func BuildPermissionReport(kubeconfig []byte, namespaces []string) (*PermissionReport, error) {
+	cfg, err := kubecmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("load generated kubeconfig err: %w", err)
+	}
+
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(namespaces) == 0 {
+		// rules are always reviewed in a namespace, cluster wide rules show up in any of them
+		namespaces = []string{"default"}
+	}
+
+	report := &PermissionReport{}
+	for _, ns := range namespaces {
+		review := &authorizationv1.SelfSubjectRulesReview{
+			Spec: authorizationv1.SelfSubjectRulesReviewSpec{Namespace: ns},
+		}
+		res, err := cs.AuthorizationV1().SelfSubjectRulesReviews().Create(context.TODO(), review, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("review rules in %s namespace err: %w", ns, err)
+		}
+		report.Namespaces = append(report.Namespaces, NamespacePermissions{
+			Namespace:        ns,
+			ResourceRules:    res.Status.ResourceRules,
+			NonResourceRules: res.Status.NonResourceRules,
+			Incomplete:       res.Status.Incomplete,
+		})
+	}
+
+	for _, attr := range clusterChecks {
+		attr := attr
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: &attr},
+		}
+		res, err := cs.AuthorizationV1().SelfSubjectAccessReviews().Create(context.TODO(), review, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("review access to %s %s err: %w", attr.Verb, attr.Resource, err)
+		}
+		report.Cluster = append(report.Cluster, AccessCheck{
+			Verb:     attr.Verb,
+			Group:    attr.Group,
+			Resource: attr.Resource,
+			Allowed:  res.Status.Allowed,
+		})
+	}
+
+	return report, nil
+}
+
+// Print writes the report as a "this identity can..." matrix.
+func (r *PermissionReport) Print(w io.Writer, username string) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	fmt.Fprintf(tw, "\n'%s' can:\n", username)
+	for _, np := range r.Namespaces {
+		fmt.Fprintf(tw, "\nin namespace %s:\n", np.Namespace)
+		fmt.Fprintln(tw, "RESOURCE\tVERBS\tNAMES")
+
+		lines := make([]string, 0, len(np.ResourceRules))
+		for _, rule := range np.ResourceRules {
+			for _, res := range qualifiedResources(rule.APIGroups, rule.Resources) {
+				lines = append(lines, fmt.Sprintf("%s\t%s\t%s", res, strings.Join(rule.Verbs, ","), strings.Join(rule.ResourceNames, ",")))
+			}
+		}
+		sort.Strings(lines)
+		for _, l := range lines {
+			fmt.Fprintln(tw, l)
+		}
+		if np.Incomplete {
+			fmt.Fprintln(tw, "(incomplete, the authorizer can not list all the rules)")
+		}
+	}
+
+	fmt.Fprintln(tw, "\nat cluster scope:")
+	fmt.Fprintln(tw, "CHECK\tALLOWED")
+	for _, c := range r.Cluster {
+		allowed := "no"
+		if c.Allowed {
+			allowed = "yes"
+		}
+		fmt.Fprintf(tw, "%s %s\t%s\n", c.Verb, qualifiedResource(c.Group, c.Resource), allowed)
+	}
+}
+
+func qualifiedResources(groups, resources []string) []string {
+	var res []string
+	for _, g := range groups {
+		for _, r := range resources {
+			res = append(res, qualifiedResource(g, r))
+		}
+	}
+	return res
+}
+
+// qualifiedResource formats a resource like kubectl does, e.g. deployments.apps.
+func qualifiedResource(group, resource string) string {
+	if group == "" {
+		return resource
+	}
+	return resource + "." + group
+}
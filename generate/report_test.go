@@ -0,0 +1,35 @@
+package generate
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+)
+
+func Test_PermissionReportPrint(t *testing.T) {
+	r := &PermissionReport{
+		Namespaces: []NamespacePermissions{
+			{
+				Namespace: "payments",
+				ResourceRules: []authorizationv1.ResourceRule{
+					{APIGroups: []string{"", "apps"}, Resources: []string{"pods"}, Verbs: []string{"get", "list"}},
+				},
+			},
+		},
+		Cluster: []AccessCheck{
+			{Verb: "list", Resource: "nodes", Allowed: false},
+		},
+	}
+
+	var buf bytes.Buffer
+	r.Print(&buf, "alice")
+	out := buf.String()
+
+	for _, want := range []string{"'alice' can:", "in namespace payments:", "pods.apps", "get,list", "list nodes"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("want %q in report but got:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package generate
+
+import (
+	"encoding/json"
+	"io/ioutil"
+)
+
+// Result is the JSON result of a run, written to the file given by -result.
+type Result struct {
+	Type         string            `json:"type"`
+	Username     string            `json:"username"`
+	Groups       []string          `json:"groups,omitempty"`
+	Kubeconfig   string            `json:"kubeconfig"`
+	Secret       string            `json:"secret,omitempty"`
+	Scope        string            `json:"scope"`
+	Namespaces   []string          `json:"namespaces,omitempty"`
+	ClusterRoles []string          `json:"clusterRoles,omitempty"`
+	Roles        []string          `json:"roles,omitempty"`
+	Permissions  *PermissionReport `json:"permissions,omitempty"`
+}
+
+func NewResult(p Params) *Result {
+	kubeconfig := p.SaveAsFile()
+	switch {
+	case p.MergeInto != "":
+		kubeconfig = p.MergeInto
+	case p.Output == StdoutOutput:
+		kubeconfig = StdoutOutput
+	case p.secretOnly():
+		kubeconfig = ""
+	}
+
+	return &Result{
+		Type:         p.Type,
+		Username:     p.Username,
+		Groups:       p.GroupSlice(),
+		Kubeconfig:   kubeconfig,
+		Secret:       p.Secret,
+		Scope:        p.Scope,
+		Namespaces:   p.NamespaceSlice(),
+		ClusterRoles: p.ClusterRoles,
+		Roles:        p.Roles,
+	}
+}
+
+func (r *Result) WriteFile(filename string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, append(data, '\n'), 0644)
+}
@@ -0,0 +1,146 @@
+package generate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/cloudflare/cfssl/log"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	readVerbs  = []string{"get", "list", "watch"}
+	writeVerbs = []string{"create", "update", "patch", "delete", "deletecollection"}
+)
+
+// Risk is a dangerous grant of a role.
+type Risk struct {
+	Role string
+	// Namespace is set for the risks of a Role, whose copies can differ
+	// between namespaces.
+	Namespace string
+	Reason    string
+}
+
+func (r Risk) String() string {
+	return fmt.Sprintf("%s: %s", r.role(), r.Reason)
+}
+
+func (r Risk) role() string {
+	if r.Namespace == "" {
+		return r.Role
+	}
+	return fmt.Sprintf("%s in %s namespace", r.Role, r.Namespace)
+}
+
+// AnalyzeRisks flags the dangerous grants in the rules of role.
+func AnalyzeRisks(role string, rules []rbacv1.PolicyRule, clusterScope bool) []Risk {
+	var reasons []string
+	if role == "cluster-admin" {
+		reasons = append(reasons, "cluster-admin grants full control")
+	}
+
+	for _, rule := range rules {
+		if contains(rule.Verbs, rbacv1.VerbAll) {
+			reasons = append(reasons, "wildcard verbs")
+		}
+		if contains(rule.Resources, rbacv1.ResourceAll) || contains(rule.APIGroups, rbacv1.APIGroupAll) {
+			reasons = append(reasons, "wildcard resources")
+		}
+		if ruleGrants(rule, "", "secrets", readVerbs...) {
+			reasons = append(reasons, "read secrets")
+		}
+		for _, v := range []string{"escalate", "bind", "impersonate"} {
+			if hasVerb(rule, v) {
+				reasons = append(reasons, fmt.Sprintf("%s verb", v))
+			}
+		}
+		for _, sub := range []string{"pods/exec", "pods/attach"} {
+			if ruleGrants(rule, "", sub, "create", "get") {
+				reasons = append(reasons, sub)
+			}
+		}
+		if ruleGrants(rule, "", "nodes/proxy", rbacv1.VerbAll, "get", "create") {
+			reasons = append(reasons, "nodes/proxy")
+		}
+		for _, res := range []string{"roles", "clusterroles", "rolebindings", "clusterrolebindings"} {
+			if ruleGrants(rule, rbacv1.GroupName, res, writeVerbs...) {
+				reasons = append(reasons, "write access to RBAC")
+			}
+		}
+		for _, res := range []string{"mutatingwebhookconfigurations", "validatingwebhookconfigurations"} {
+			if ruleGrants(rule, "admissionregistration.k8s.io", res, writeVerbs...) {
+				reasons = append(reasons, "write access to admission webhooks")
+			}
+		}
+	}
+
+	var risks []Risk
+	for _, reason := range uniqueStrings(reasons) {
+		if clusterScope {
+			reason += " at cluster scope"
+		}
+		risks = append(risks, Risk{Role: role, Reason: reason})
+	}
+	return risks
+}
+
+func contains(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func hasVerb(rule rbacv1.PolicyRule, verb string) bool {
+	return contains(rule.Verbs, verb) || contains(rule.Verbs, rbacv1.VerbAll)
+}
+
+// ruleGrants tells whether rule grants any of verbs on the resource.
+func ruleGrants(rule rbacv1.PolicyRule, group, resource string, verbs ...string) bool {
+	if !contains(rule.APIGroups, group) && !contains(rule.APIGroups, rbacv1.APIGroupAll) {
+		return false
+	}
+	if !contains(rule.Resources, resource) && !contains(rule.Resources, rbacv1.ResourceAll) {
+		return false
+	}
+	for _, v := range verbs {
+		if hasVerb(rule, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// GrantRisks returns the dangerous grants of the roles of p. Every
+// namespace's copy of a Role is analysed, as they can differ.
+func (kt *Client) GrantRisks(p Params) ([]Risk, error) {
+	clusterScope := p.Scope == ClusterScope
+
+	var risks []Risk
+	for _, cr := range p.ClusterRoles {
+		rules, err := kt.ClusterRoleRules(cr)
+		if err != nil {
+			return nil, fmt.Errorf("get rules of cluster role %s err: %w", cr, err)
+		}
+		risks = append(risks, AnalyzeRisks(cr, rules, clusterScope)...)
+	}
+	for _, r := range p.Roles {
+		for _, ns := range p.NamespaceSlice() {
+			role, err := kt.client.RbacV1().Roles(ns).Get(context.TODO(), r, metav1.GetOptions{})
+			if err != nil {
+				log.Debugf("get role %s in %s namespace err: %v", r, ns, err)
+				continue
+			}
+			for _, risk := range AnalyzeRisks(r, role.Rules, false) {
+				risk.Namespace = ns
+				risks = append(risks, risk)
+			}
+		}
+	}
+	risks = append(risks, AnalyzeRisks(CustomRoleName(p.subject()), p.CustomRules, clusterScope)...)
+	return risks, nil
+}
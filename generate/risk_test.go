@@ -0,0 +1,84 @@
+package generate
+
+import (
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_AnalyzeRisks(t *testing.T) {
+	cases := []struct {
+		name   string
+		role   string
+		rules  []rbacv1.PolicyRule
+		reason string
+	}{
+		{
+			name:   "cluster-admin",
+			role:   "cluster-admin",
+			rules:  []rbacv1.PolicyRule{{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"*"}}},
+			reason: "cluster-admin grants full control",
+		},
+		{
+			name:   "secrets",
+			rules:  []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"list"}}},
+			reason: "read secrets",
+		},
+		{
+			name:   "exec",
+			rules:  []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods/exec"}, Verbs: []string{"create"}}},
+			reason: "pods/exec",
+		},
+		{
+			name:   "rbac write",
+			rules:  []rbacv1.PolicyRule{{APIGroups: []string{"rbac.authorization.k8s.io"}, Resources: []string{"rolebindings"}, Verbs: []string{"create"}}},
+			reason: "write access to RBAC",
+		},
+		{
+			name:   "escalate",
+			rules:  []rbacv1.PolicyRule{{APIGroups: []string{"rbac.authorization.k8s.io"}, Resources: []string{"clusterroles"}, Verbs: []string{"escalate"}}},
+			reason: "escalate verb",
+		},
+	}
+
+	for _, tc := range cases {
+		risks := AnalyzeRisks(tc.role, tc.rules, false)
+		found := false
+		for _, r := range risks {
+			if r.Reason == tc.reason {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s: want risk %q but got %v", tc.name, tc.reason, risks)
+		}
+	}
+
+	view := []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods", "configmaps"}, Verbs: []string{"get", "list", "watch"}}}
+	if risks := AnalyzeRisks("view", view, true); len(risks) != 0 {
+		t.Errorf("want no risk but got %v", risks)
+	}
+}
+
+func Test_GrantRisks(t *testing.T) {
+	role := func(ns string, rules ...rbacv1.PolicyRule) *rbacv1.Role {
+		return &rbacv1.Role{ObjectMeta: metav1.ObjectMeta{Name: "deployer", Namespace: ns}, Rules: rules}
+	}
+	c := NewClient(fake.NewSimpleClientset(
+		role("dev", rbacv1.PolicyRule{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"get"}}),
+		role("prod", rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get"}}),
+	))
+
+	risks, err := c.GrantRisks(Params{Username: "alice", Roles: []string{"deployer"}, Namespaces: "dev,prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(risks) != 1 || risks[0].Namespace != "prod" {
+		t.Fatalf("want the risk of the prod copy of the role but got %v", risks)
+	}
+	if want := "deployer in prod namespace: read secrets"; risks[0].String() != want {
+		t.Errorf("want %q but got %q", want, risks[0].String())
+	}
+}
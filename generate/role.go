@@ -0,0 +1,312 @@
+package generate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/cloudflare/cfssl/log"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+const coreGroupName = "core"
+
+var customRoleVerbs = []string{"get", "list", "watch", "create", "update", "patch", "delete", "deletecollection"}
+
+// RoleSpec describes the rules of a custom role, it is loaded from the file given by -role-spec.
+type RoleSpec struct {
+	Rules []rbacv1.PolicyRule `json:"rules"`
+}
+
+func LoadRoleSpec(filename string) ([]rbacv1.PolicyRule, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var spec RoleSpec
+	if err := yaml.UnmarshalStrict(data, &spec); err != nil {
+		return nil, fmt.Errorf("parse role spec %s err: %w", filename, err)
+	}
+
+	if len(spec.Rules) == 0 {
+		return nil, fmt.Errorf("not found any rule in role spec %s", filename)
+	}
+
+	return spec.Rules, nil
+}
+
+// CustomRoleName is the name of the dedicated Role or ClusterRole created for
+// subject. Like binding names, the hash suffix keeps users and service
+// accounts of the same name apart.
+func CustomRoleName(subject rbacv1.Subject) string {
+	kind := "user"
+	if subject.Kind == rbacv1.ServiceAccountKind {
+		kind = "sa"
+	}
+
+	parts := []string{"gen-kubecfg:custom", kind}
+	if subject.Namespace != "" {
+		parts = append(parts, subject.Namespace)
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{subject.Kind, subject.Namespace, subject.Name}, "/")))
+	parts = append(parts, subject.Name, hex.EncodeToString(sum[:])[:8])
+
+	// '/' and '%' are not allowed in the name of rbac objects
+	return strings.NewReplacer("/", "-", "%", "-").Replace(strings.Join(parts, ":"))
+}
+
+// GetAPIResources returns the resource names served by the cluster, grouped by api group.
+func (kt *Client) GetAPIResources() map[string][]string {
+	lists, err := kt.client.Discovery().ServerPreferredResources()
+	if err != nil {
+		// partial results are still useful, e.g. when an aggregated api server is down
+		log.Warningf("discovery api resources err: %v", err)
+	}
+
+	res := make(map[string][]string)
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, r := range list.APIResources {
+			res[gv.Group] = append(res[gv.Group], r.Name)
+		}
+	}
+
+	for g := range res {
+		sort.Strings(res[g])
+	}
+	return res
+}
+
+// AskCustomRole asks whether to author a dedicated role for the user, and the
+// rules of it. Rules are loaded from p.RoleSpec instead if it is set.
+func AskCustomRole(c *Client, p *Params) {
+	if p.RoleSpec != "" {
+		rules, err := LoadRoleSpec(p.RoleSpec)
+		if err != nil {
+			log.Fatalf("load role spec err: %v", err)
+		}
+		p.CustomRules = rules
+		return
+	}
+
+	var custom bool
+	prompt := &survey.Confirm{
+		Message: "Do you want to create a custom role for this user?",
+		Default: false,
+	}
+	if err := survey.AskOne(prompt, &custom, p.AskOpt()); err != nil {
+		log.Fatalf("got questions answers err: %v", err)
+	}
+	if !custom {
+		return
+	}
+
+	resources := c.GetAPIResources()
+	var groups []string
+	for g := range resources {
+		if g == "" {
+			g = coreGroupName
+		}
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+
+	for {
+		rule, err := askPolicyRule(groups, resources, p.AskOpt())
+		if err != nil {
+			log.Fatalf("got questions answers err: %v", err)
+		}
+		p.CustomRules = append(p.CustomRules, rule)
+
+		var more bool
+		if err := survey.AskOne(&survey.Confirm{Message: "Add another rule?", Default: false}, &more, p.AskOpt()); err != nil {
+			log.Fatalf("got questions answers err: %v", err)
+		}
+		if !more {
+			return
+		}
+	}
+}
+
+func askPolicyRule(groups []string, resources map[string][]string, opt survey.AskOpt) (rbacv1.PolicyRule, error) {
+	var rule rbacv1.PolicyRule
+
+	var chosenGroups []string
+	groupQ := &survey.MultiSelect{
+		Message: "Please choose api groups of the rule:",
+		Options: groups,
+	}
+	if err := survey.AskOne(groupQ, &chosenGroups, survey.WithValidator(survey.Required), opt); err != nil {
+		return rule, err
+	}
+
+	var options []string
+	for _, g := range chosenGroups {
+		if g == coreGroupName {
+			g = ""
+		}
+		rule.APIGroups = append(rule.APIGroups, g)
+		options = append(options, resources[g]...)
+	}
+	options = uniqueStrings(options)
+
+	var chosenResources []string
+	resourceQ := &survey.MultiSelect{
+		Message: "Please choose resources of the rule:",
+		Options: options,
+	}
+	if err := survey.AskOne(resourceQ, &chosenResources, survey.WithValidator(survey.Required), opt); err != nil {
+		return rule, err
+	}
+	rule.Resources = chosenResources
+
+	verbQ := &survey.MultiSelect{
+		Message: "Please choose verbs of the rule:",
+		Options: customRoleVerbs,
+	}
+	if err := survey.AskOne(verbQ, &rule.Verbs, survey.WithValidator(survey.Required), opt); err != nil {
+		return rule, err
+	}
+
+	var names string
+	namesQ := &survey.Input{
+		Message: "Please input resource names of the rule, split by ',' (optional):",
+	}
+	if err := survey.AskOne(namesQ, &names, opt); err != nil {
+		return rule, err
+	}
+	for _, n := range strings.Split(names, ",") {
+		if n = strings.TrimSpace(n); n != "" {
+			rule.ResourceNames = append(rule.ResourceNames, n)
+		}
+	}
+
+	return rule, nil
+}
+
+func uniqueStrings(in []string) []string {
+	seen := make(map[string]bool, len(in))
+	var res []string
+	for _, s := range in {
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+		res = append(res, s)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// ApplyCustomRole creates or updates the dedicated role of the user when it has
+// custom rules, a ClusterRole for cluster scope and a Role in every namespace
+// otherwise, and records it in p so it is bound by GenerateBinding.
+func (kt *Client) ApplyCustomRole(p *Params) error {
+	if len(p.CustomRules) == 0 {
+		return nil
+	}
+
+	subject := p.subject()
+	name := CustomRoleName(subject)
+	owner := subjectString(subject)
+	namespaces := p.NamespaceSlice()
+
+	if len(namespaces) == 0 {
+		if err := kt.applyClusterRole(name, p.CustomRules, owner); err != nil {
+			return fmt.Errorf("apply cluster role %s err: %w", name, err)
+		}
+		log.Infof("apply custom cluster role %s success", name)
+		p.ClusterRoles = append(p.ClusterRoles, name)
+		return nil
+	}
+
+	for _, ns := range namespaces {
+		if err := kt.EnsureNamespace(ns, *p); err != nil {
+			return err
+		}
+		if err := kt.applyRole(ns, name, p.CustomRules, owner); err != nil {
+			return fmt.Errorf("apply role %s in %s namespace err: %w", name, ns, err)
+		}
+		log.Infof("apply custom role %s in %s namespace success", name, ns)
+	}
+	p.Roles = append(p.Roles, name)
+
+	return nil
+}
+
+// applyClusterRole creates or updates the cluster role. With owner, the role
+// is annotated with the subject it is created for, and a role annotated for
+// another subject is not updated.
+func (kt *Client) applyClusterRole(name string, rules []rbacv1.PolicyRule, owner string) error {
+	cr := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Rules: rules,
+	}
+	if owner != "" {
+		cr.Annotations = map[string]string{SubjectAnnotation: owner}
+	}
+
+	old, err := kt.client.RbacV1().ClusterRoles().Get(context.TODO(), name, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		if err := checkRoleOwner("cluster role "+name, old.Annotations, owner); err != nil {
+			return err
+		}
+		cr.ResourceVersion = old.ResourceVersion
+		_, err = kt.client.RbacV1().ClusterRoles().Update(context.TODO(), cr, metav1.UpdateOptions{})
+	case apierrors.IsNotFound(err):
+		_, err = kt.client.RbacV1().ClusterRoles().Create(context.TODO(), cr, metav1.CreateOptions{})
+	}
+
+	return err
+}
+
+func (kt *Client) applyRole(namespace, name string, rules []rbacv1.PolicyRule, owner string) error {
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Rules: rules,
+	}
+	if owner != "" {
+		role.Annotations = map[string]string{SubjectAnnotation: owner}
+	}
+
+	old, err := kt.client.RbacV1().Roles(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		if err := checkRoleOwner(fmt.Sprintf("role %s/%s", namespace, name), old.Annotations, owner); err != nil {
+			return err
+		}
+		role.ResourceVersion = old.ResourceVersion
+		_, err = kt.client.RbacV1().Roles(namespace).Update(context.TODO(), role, metav1.UpdateOptions{})
+	case apierrors.IsNotFound(err):
+		_, err = kt.client.RbacV1().Roles(namespace).Create(context.TODO(), role, metav1.CreateOptions{})
+	}
+
+	return err
+}
+
+// checkRoleOwner refuses to update a role created for another subject.
+func checkRoleOwner(role string, annotations map[string]string, owner string) error {
+	if existing, ok := annotations[SubjectAnnotation]; ok && owner != "" && existing != owner {
+		return fmt.Errorf("%s belongs to %s instead of %s", role, existing, owner)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package generate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_LoadRoleSpec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "role-spec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "role.yaml")
+	spec := `rules:
+- apiGroups: [""]
+  resources: ["pods", "pods/log"]
+  verbs: ["get", "list", "watch"]
+`
+	if err := ioutil.WriteFile(filename, []byte(spec), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := LoadRoleSpec(filename)
+	if err != nil {
+		t.Fatalf("load role spec err: %v", err)
+	}
+
+	if len(rules) != 1 || !reflect.DeepEqual(rules[0].Resources, []string{"pods", "pods/log"}) {
+		t.Errorf("unexpected rules: %+v", rules)
+	}
+
+	if err := ioutil.WriteFile(filename, []byte("rules: []\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRoleSpec(filename); err == nil {
+		t.Errorf("want err for empty rules but got nil")
+	}
+}
+
+func Test_CustomRoleName(t *testing.T) {
+	names := map[string]bool{}
+	for _, s := range []rbacv1.Subject{
+		NewSubject("User", "deploy", ""),
+		NewSubject("ServiceAccount", "deploy", "a"),
+		NewSubject("ServiceAccount", "deploy", "b"),
+	} {
+		names[CustomRoleName(s)] = true
+	}
+	if len(names) != 3 {
+		t.Errorf("want a custom role name per subject but got %v", names)
+	}
+}
+
+func Test_ApplyCustomRoleOwner(t *testing.T) {
+	c := NewClient(fake.NewSimpleClientset())
+	rules := []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}}
+
+	if err := c.applyClusterRole("r", rules, "User/alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.applyClusterRole("r", rules, "User/alice"); err != nil {
+		t.Errorf("want the role of the same subject updated but got %v", err)
+	}
+	if err := c.applyClusterRole("r", rules, "User/bob"); err == nil {
+		t.Errorf("want err for the role of another subject but got nil")
+	}
+}
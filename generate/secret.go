@@ -0,0 +1,210 @@
+package generate
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/cloudflare/cfssl/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	kubecmd "k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// SecretKubeconfigKey is the key of the kubeconfig in the delivered secret.
+	SecretKubeconfigKey = "kubeconfig"
+
+	managedByLabel   = "app.kubernetes.io/managed-by"
+	subjectKindLabel = "gen-kubecfg/subject-kind"
+	subjectNameLabel = "gen-kubecfg/subject-name"
+)
+
+var invalidLabelChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// SecretNamespaceName splits p.Secret, which is given as namespace/name.
+func (p Params) SecretNamespaceName() (string, string, error) {
+	parts := strings.Split(p.Secret, "/")
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid secret %q: want namespace/name", p.Secret)
+	}
+	if errs := validation.IsDNS1123Label(parts[0]); len(errs) > 0 {
+		return "", "", fmt.Errorf("invalid secret namespace %q: %s", parts[0], strings.Join(errs, ", "))
+	}
+	if errs := validation.IsDNS1123Subdomain(parts[1]); len(errs) > 0 {
+		return "", "", fmt.Errorf("invalid secret name %q: %s", parts[1], strings.Join(errs, ", "))
+	}
+	return parts[0], parts[1], nil
+}
+
+// secretOnly tells if the kubeconfig is only delivered as a secret, no file
+// is written unless -output is given as well.
+func (p Params) secretOnly() bool {
+	return p.Secret != "" && p.Output == ""
+}
+
+// BuildKubeConfigSecret builds the secret holding the kubeconfig of the user,
+// labelled with the subject. With p.SecretParts the CA, client certificate,
+// key and token are stored under their own keys too.
+func BuildKubeConfigSecret(p Params) (*corev1.Secret, error) {
+	namespace, name, err := p.SecretNamespaceName()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := kubecmd.Write(*BuildKubeConfig(p))
+	if err != nil {
+		return nil, fmt.Errorf("serialize kubeconfig err: %w", err)
+	}
+
+	subject := p.subject()
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				managedByLabel:   "gen-kubecfg",
+				subjectKindLabel: subject.Kind,
+			},
+			Annotations: map[string]string{SubjectAnnotation: subjectString(subject)},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{SecretKubeconfigKey: data},
+	}
+	// the annotation keeps the exact subject, the label is for selecting
+	if v := labelValue(subject.Name); v != "" {
+		secret.Labels[subjectNameLabel] = v
+	}
+
+	if p.SecretParts {
+		if p.ClusterCA != "" {
+			secret.Data[corev1.ServiceAccountRootCAKey] = []byte(p.ClusterCA)
+		}
+		if p.Type == ClientCertType {
+			secret.Data[corev1.TLSCertKey] = []byte(p.ClientCert)
+			secret.Data[corev1.TLSPrivateKeyKey] = []byte(p.ClientKey)
+		} else {
+			secret.Data[corev1.ServiceAccountTokenKey] = []byte(p.Token)
+		}
+	}
+
+	return secret, nil
+}
+
+// labelValue turns s into a label value, it is empty if that is not possible.
+func labelValue(s string) string {
+	v := invalidLabelChars.ReplaceAllString(s, "-")
+	if len(v) > validation.LabelValueMaxLength {
+		v = v[:validation.LabelValueMaxLength]
+	}
+	v = strings.Trim(v, "-_.")
+	if len(validation.IsValidLabelValue(v)) > 0 {
+		return ""
+	}
+	return v
+}
+
+// OwnerServiceAccount returns the service account whose token the kubeconfig
+// of p holds, it is nil for client certificates.
+func (kt *Client) OwnerServiceAccount(p Params) (*corev1.ServiceAccount, error) {
+	var name string
+	switch p.Type {
+	case TokenType:
+		name = p.Username
+	case ImpersonateType:
+		name = p.Gateway
+	default:
+		return nil, nil
+	}
+	return kt.client.CoreV1().ServiceAccounts(p.ServiceAccountNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+// SetServiceAccountOwner makes sa the owner of secret, so the secret is
+// deleted with the service account. Owners can not be in another namespace,
+// false is returned then.
+func SetServiceAccountOwner(secret *corev1.Secret, sa *corev1.ServiceAccount) bool {
+	if sa == nil || sa.Namespace != secret.Namespace {
+		return false
+	}
+	secret.OwnerReferences = []metav1.OwnerReference{{
+		APIVersion: "v1",
+		Kind:       "ServiceAccount",
+		Name:       sa.Name,
+		UID:        sa.UID,
+	}}
+	return true
+}
+
+// CheckKubeConfigSecret checks that the secret of p can be delivered: its
+// namespace exists or may be created, and an existing secret was generated
+// for the same subject unless p.OverwriteSecret is set. It runs before anything is
+// created in the cluster.
+func (kt *Client) CheckKubeConfigSecret(p Params) error {
+	namespace, name, err := p.SecretNamespaceName()
+	if err != nil {
+		return err
+	}
+
+	if err := kt.CheckNamespace(namespace, p); err != nil {
+		return err
+	}
+
+	old, err := kt.client.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		return checkSecretOwner(old, subjectString(p.subject()), p.OverwriteSecret)
+	case apierrors.IsNotFound(err):
+		return nil
+	case apierrors.IsForbidden(err):
+		log.Warningf("can not check secret %s/%s: %v", namespace, name, err)
+		return nil
+	}
+	return err
+}
+
+// checkSecretOwner refuses to replace a secret which was not generated for
+// subject unless overwrite is set.
+func checkSecretOwner(secret *corev1.Secret, subject string, overwrite bool) error {
+	owner, ok := secret.Annotations[SubjectAnnotation]
+	switch {
+	case overwrite || ok && owner == subject:
+		return nil
+	case !ok:
+		return fmt.Errorf("secret %s/%s is not generated by gen-kubecfg, use -overwrite-secret to replace it", secret.Namespace, secret.Name)
+	}
+	return fmt.Errorf("secret %s/%s belongs to %s, use -overwrite-secret to replace it", secret.Namespace, secret.Name, owner)
+}
+
+// ApplyKubeConfigSecret creates or updates secret. A secret which was not
+// generated for the same subject is only replaced with p.OverwriteSecret.
+func (kt *Client) ApplyKubeConfigSecret(secret *corev1.Secret, p Params) error {
+	if err := kt.EnsureNamespace(secret.Namespace, p); err != nil {
+		return err
+	}
+
+	secrets := kt.client.CoreV1().Secrets(secret.Namespace)
+	old, err := secrets.Get(context.TODO(), secret.Name, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		if err := checkSecretOwner(old, secret.Annotations[SubjectAnnotation], p.OverwriteSecret); err != nil {
+			return err
+		}
+		secret.ResourceVersion = old.ResourceVersion
+		_, err = secrets.Update(context.TODO(), secret, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+	case apierrors.IsNotFound(err):
+		if _, err := secrets.Create(context.TODO(), secret, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	log.Infof("save kubeconfig for user '%s' as secret %s/%s success", p.Username, secret.Namespace, secret.Name)
+	return nil
+}
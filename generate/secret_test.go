@@ -0,0 +1,143 @@
+package generate
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func Test_BuildKubeConfigSecret(t *testing.T) {
+	p := Params{
+		Type:                    TokenType,
+		ClusterName:             "prod",
+		ClusterCA:               "ca",
+		Username:                "ci-runner",
+		ServiceAccountNamespace: "ci",
+		Token:                   "secret",
+		Secret:                  "ci/ci-runner-kubeconfig",
+		SecretParts:             true,
+	}
+
+	secret, err := BuildKubeConfigSecret(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Namespace != "ci" || secret.Name != "ci-runner-kubeconfig" {
+		t.Errorf("want secret ci/ci-runner-kubeconfig but got %s/%s", secret.Namespace, secret.Name)
+	}
+	if secret.Labels[subjectKindLabel] != "ServiceAccount" || secret.Labels[subjectNameLabel] != "ci-runner" {
+		t.Errorf("want subject labels but got %v", secret.Labels)
+	}
+	if len(secret.Data[SecretKubeconfigKey]) == 0 || string(secret.Data["token"]) != "secret" || string(secret.Data["ca.crt"]) != "ca" {
+		t.Errorf("want kubeconfig, token and ca but got keys %v", secret.Data)
+	}
+
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "ci-runner", Namespace: "ci", UID: "uid"}}
+	if !SetServiceAccountOwner(secret, sa) || secret.OwnerReferences[0].UID != "uid" {
+		t.Errorf("want the service account as owner but got %v", secret.OwnerReferences)
+	}
+	sa.Namespace = "other"
+	if SetServiceAccountOwner(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "ci"}}, sa) {
+		t.Errorf("want no owner from another namespace")
+	}
+
+	if _, err := BuildKubeConfigSecret(Params{Secret: "no-namespace"}); err == nil {
+		t.Errorf("want err for a secret without namespace but got nil")
+	}
+}
+
+func Test_labelValue(t *testing.T) {
+	cases := map[string]string{
+		"alice":                     "alice",
+		"alice@example.com":         "alice-example.com",
+		"system:serviceaccount:a:b": "system-serviceaccount-a-b",
+		"::":                        "",
+	}
+	for in, want := range cases {
+		if got := labelValue(in); got != want {
+			t.Errorf("%q: want %q but got %q", in, want, got)
+		}
+	}
+}
+
+func Test_ApplyKubeConfigSecret(t *testing.T) {
+	c := NewClient(fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ci"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "foreign", Namespace: "ci"}},
+	))
+
+	p := Params{Type: ClientCertType, ClusterName: "prod", Username: "alice", Secret: "ci/alice"}
+	secret, err := BuildKubeConfigSecret(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ApplyKubeConfigSecret(secret, p); err != nil {
+		t.Fatal(err)
+	}
+	// applying again updates the secret of the same subject
+	secret, _ = BuildKubeConfigSecret(p)
+	if err := c.ApplyKubeConfigSecret(secret, p); err != nil {
+		t.Fatal(err)
+	}
+
+	other := p
+	other.Username = "bob"
+	secret, _ = BuildKubeConfigSecret(other)
+	if err := c.ApplyKubeConfigSecret(secret, other); err == nil {
+		t.Errorf("want err for a secret of another subject but got nil")
+	}
+
+	p.Secret = "ci/foreign"
+	secret, _ = BuildKubeConfigSecret(p)
+	if err := c.ApplyKubeConfigSecret(secret, p); err == nil {
+		t.Errorf("want err for a secret not generated by gen-kubecfg but got nil")
+	}
+	// -overwrite only replaces the kubeconfig file
+	p.Overwrite = true
+	if err := c.ApplyKubeConfigSecret(secret, p); err == nil {
+		t.Errorf("want err for a foreign secret with -overwrite but got nil")
+	}
+	p.OverwriteSecret = true
+	if err := c.ApplyKubeConfigSecret(secret, p); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.ClientSet().CoreV1().Secrets("ci").Get(context.TODO(), "foreign", metav1.GetOptions{})
+	if err != nil || got.Annotations[SubjectAnnotation] != "User/alice" {
+		t.Errorf("want the overwritten secret replaced but got %v, err: %v", got, err)
+	}
+}
+
+func Test_CheckKubeConfigSecret(t *testing.T) {
+	c := NewClient(fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ci"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "foreign", Namespace: "ci"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{
+			Name: "bob", Namespace: "ci", Annotations: map[string]string{SubjectAnnotation: "User/bob"},
+		}},
+	))
+
+	cases := []struct {
+		name    string
+		p       Params
+		wantErr bool
+	}{
+		{name: "new secret", p: Params{Username: "alice", Secret: "ci/alice"}},
+		{name: "missing namespace", p: Params{Username: "alice", Secret: "cd/alice"}, wantErr: true},
+		{name: "created namespace", p: Params{Username: "alice", Secret: "cd/alice", CreateNamespaces: true}},
+		{name: "foreign secret", p: Params{Username: "alice", Secret: "ci/foreign"}, wantErr: true},
+		{name: "secret of another subject", p: Params{Username: "alice", Secret: "ci/bob"}, wantErr: true},
+		{name: "secret of the subject", p: Params{Username: "bob", Secret: "ci/bob"}},
+		{name: "overwrite file", p: Params{Username: "alice", Secret: "ci/foreign", Overwrite: true}, wantErr: true},
+		{name: "overwrite secret", p: Params{Username: "alice", Secret: "ci/foreign", OverwriteSecret: true}},
+	}
+
+	for _, tc := range cases {
+		if err := c.CheckKubeConfigSecret(tc.p); (err != nil) != tc.wantErr {
+			t.Errorf("%s: want err %v but got %v", tc.name, tc.wantErr, err)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package generate
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/cloudflare/cfssl/log"
+)
+
+// AskOpt makes survey prompt on the console of p.
+func (p Params) AskOpt() survey.AskOpt {
+	return survey.WithStdio(os.Stdin, p.ConsoleOut(), os.Stderr)
+}
+
+// AskScope asks for the permission scope of the user and the cluster roles
+// (and namespaces) to bind, it is shared by all generators.
+func AskScope(c *Client, p *Params) {
+	clusterRoleNames := c.GetClusterRoleNames()
+
+	var scopeTypeQ = []*survey.Question{
+		{
+			Name: "scope",
+			Prompt: &survey.Select{
+				Message: "Please choose permission scope for this user:",
+				Options: []string{ClusterScope, NamespaceScope},
+				Default: ClusterScope,
+			},
+		},
+	}
+	if p.Onboard {
+		// the tenant namespaces are what onboard mode sets up
+		p.Scope = NamespaceScope
+	} else if err := survey.Ask(scopeTypeQ, p, p.AskOpt()); err != nil {
+		log.Fatalf("got questions answers err: %v", err)
+	}
+
+	var roleNames []string
+	if p.Scope == NamespaceScope {
+		askNamespaces(c, p)
+		roleNames = c.GetRoleNames(p.NamespaceSlice())
+	}
+
+	for {
+		var scopeQ = []*survey.Question{
+			{
+				Name: "clusterRoles",
+				Prompt: &survey.MultiSelect{
+					Message: "Please choose some cluster roles:",
+					Options: clusterRoleNames,
+					Default: p.ClusterRoles,
+				},
+			},
+		}
+		if len(roleNames) > 0 {
+			scopeQ = append(scopeQ, &survey.Question{
+				Name: "roles",
+				Prompt: &survey.MultiSelect{
+					Message: "Please choose some roles (bound in the namespaces which have them):",
+					Options: roleNames,
+					Default: p.Roles,
+				},
+			})
+		}
+		if err := survey.Ask(scopeQ, p, p.AskOpt()); err != nil {
+			log.Fatalf("got questions answers err: %v", err)
+		}
+
+		// the custom rules are asked again with the roles, the preview
+		// shows what they grant together
+		p.CustomRules = nil
+		AskCustomRole(c, p)
+
+		if previewRules(c, p) {
+			break
+		}
+	}
+
+	confirmRisks(c, p)
+}
+
+func askNamespaces(c *Client, p *Params) {
+	if p.NamespaceSelector != "" || p.NamespaceGlob != "" {
+		if err := c.ResolveNamespaces(p); err != nil {
+			log.Fatalf("resolve namespaces err: %v", err)
+		}
+		log.Infof("resolved namespaces: %s", strings.Join(p.NamespaceSlice(), ","))
+	} else if names, err := c.GetNamespaceNames(""); err == nil && len(names) > 0 && !p.Onboard {
+		var chosen []string
+		prompt := &survey.MultiSelect{
+			Message: "Please choose namespaces you want to generate kubeconfig for:",
+			Options: names,
+		}
+		if err := survey.AskOne(prompt, &chosen, survey.WithValidator(survey.Required), p.AskOpt()); err != nil {
+			log.Fatalf("got questions answers err: %v", err)
+		}
+		p.Namespaces = strings.Join(chosen, ",")
+	} else {
+		// fall back to input when namespaces can not be listed, or new
+		// namespaces are onboarded
+		var nsQ = []*survey.Question{
+			{
+				Name: "namespaces",
+				Prompt: &survey.Input{
+					Message: "Please input namespaces you want to generate kubeconfig for, split by ',':",
+				},
+				Validate: survey.Required,
+			},
+		}
+		if err := survey.Ask(nsQ, p, p.AskOpt()); err != nil {
+			log.Fatalf("got questions answers err: %v", err)
+		}
+	}
+
+	if err := p.ValidateNamespaces(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	for _, ns := range p.NamespaceSlice() {
+		if err := c.CheckNamespace(ns, *p); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+}
+
+// AskServer offers the discovered endpoints of the cluster as the server of
+// the generated kubeconfig, with the probe result of each. The preferred
+// endpoint is taken without asking when p.Server is ServerAuto or it is the
+// only one, which fails if the user can not connect to it.
+func AskServer(c *Client, p *Params) {
+	if p.Server != "" && p.Server != ServerAuto {
+		return
+	}
+
+	ca := p.ClusterCA
+	if p.CAFileRef != "" {
+		// the referenced file is on the machine of the user, it is probed
+		// with if it is here too
+		var err error
+		if ca, err = readCAFile(p.CAFileRef); err != nil {
+			log.Warningf("read CA file %s err: %v, the endpoints are probed against the system roots", p.CAFileRef, err)
+		}
+	}
+
+	endpoints := c.DiscoverEndpoints(p.ClusterEndpoint)
+	ProbeEndpoints(endpoints, ca, p.TLSServerName)
+	PrintEndpoints(p.ConsoleOut(), endpoints)
+
+	preferred := PreferredEndpoint(endpoints)
+	if p.Server == ServerAuto || len(endpoints) == 1 {
+		switch {
+		case preferred.Loopback():
+			log.Fatalf("server %s is a loopback address the user can not reach, set the server with -server", preferred.Server)
+		case !preferred.Usable():
+			log.Fatalf("server %s is not usable by the user: %v, set the server with -server", preferred.Server, preferred.Err)
+		}
+		p.Server = preferred.Server
+		return
+	}
+
+	options := make([]string, 0, len(endpoints))
+	for _, e := range endpoints {
+		options = append(options, e.Server)
+	}
+	prompt := &survey.Select{
+		Message: "Please choose the server the user connects to:",
+		Options: options,
+		Default: preferred.Server,
+	}
+	if err := survey.AskOne(prompt, &p.Server, p.AskOpt()); err != nil {
+		log.Fatalf("got questions answers err: %v", err)
+	}
+}
+
+// OmitSaveAs drops the save as question when the kubeconfig file is given by
+// -output, or the kubeconfig is written to stdout or only delivered as a secret.
+func OmitSaveAs(qs []*survey.Question, p Params) []*survey.Question {
+	if p.SaveAs == "" && p.Output != StdoutOutput && !p.secretOnly() {
+		return qs
+	}
+
+	res := make([]*survey.Question, 0, len(qs))
+	for _, q := range qs {
+		if q.Name != "saveAs" {
+			res = append(res, q)
+		}
+	}
+	return res
+}
+
+// NamespaceValidator validates that the answer is an existing namespace, or
+// that namespaces are allowed to be created by p.
+func NamespaceValidator(c *Client, p *Params) survey.Validator {
+	return func(ans interface{}) error {
+		ns, _ := ans.(string)
+		if err := survey.Required(ns); err != nil {
+			return err
+		}
+		return c.CheckNamespace(ns, *p)
+	}
+}
+
+// previewRules shows the merged permissions of the chosen roles and the custom
+// rules before anything is created, and asks whether to grant them.
+func previewRules(c *Client, p *Params) bool {
+	if len(p.ClusterRoles) == 0 && len(p.Roles) == 0 && len(p.CustomRules) == 0 {
+		return true
+	}
+
+	rules, err := c.SelectedRules(*p)
+	if err != nil {
+		log.Warningf("preview permissions err: %v", err)
+		return true
+	}
+	rules = append(rules, p.CustomRules...)
+
+	merged := MergeRules(rules, c.ClusterScopedResources(), p.Scope == NamespaceScope)
+	console := p.ConsoleOut()
+	fmt.Fprintf(console, "\nThe chosen roles and custom rules grant these permissions (%s scope):\n", p.Scope)
+	PrintResourceVerbs(console, merged)
+	fmt.Fprintln(console)
+
+	ok := true
+	prompt := &survey.Confirm{
+		Message: "Grant these permissions? ('n' to choose roles and custom rules again)",
+		Default: true,
+	}
+	if err := survey.AskOne(prompt, &ok, p.AskOpt()); err != nil {
+		log.Fatalf("got questions answers err: %v", err)
+	}
+	return ok
+}
+
+// confirmRisks flags the dangerous grants of the chosen roles, each of them has
+// to be confirmed unless p.AllowPrivileged is set.
+func confirmRisks(c *Client, p *Params) {
+	risks, err := c.GrantRisks(*p)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	for _, r := range risks {
+		log.Warningf("privileged grant %s", r)
+		if p.AllowPrivileged {
+			continue
+		}
+
+		var ok bool
+		prompt := &survey.Confirm{
+			Message: fmt.Sprintf("Grant '%s' to '%s' anyway? (%s)", r.role(), p.Username, r.Reason),
+			Default: false,
+		}
+		if err := survey.AskOne(prompt, &ok, p.AskOpt()); err != nil {
+			log.Fatalf("got questions answers err: %v", err)
+		}
+		if !ok {
+			log.Fatalf("privileged grant %s is not confirmed, nothing is created", r)
+		}
+	}
+}
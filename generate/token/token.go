@@ -1,6 +1,7 @@
 package token
 
 import (
+	"context"
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/cloudflare/cfssl/log"
 	"github.com/yahaa/gen-kubecfg/generate"
@@ -22,12 +23,12 @@ func New(c generate.Client) generate.Generator {
 }
 
 func (g *tokenKubeconfig) Generate(p *generate.Params) {
-	generate.KubeConfig(*p)
+	if err := generate.KubeConfig(*p); err != nil {
+		log.Fatalf("%v", err)
+	}
 }
 
 func (g *tokenKubeconfig) ParseParams(p *generate.Params) {
-	clusterRoleNames := g.client.GetClusterRoleNames()
-
 	var commonQ = []*survey.Question{
 		{
 			Name: "existedSA",
@@ -39,18 +40,10 @@ func (g *tokenKubeconfig) ParseParams(p *generate.Params) {
 		{
 			Name:     "serviceAccountNamespace",
 			Prompt:   &survey.Input{Message: "Please input namespace of the service account:"},
-			Validate: survey.Required,
-		},
-		{
-			Name: "scope",
-			Prompt: &survey.Select{
-				Message: "Please choose permission scope for this user:",
-				Options: []string{generate.ClusterScope, generate.NamespaceScope},
-				Default: generate.ClusterScope,
-			},
+			Validate: generate.NamespaceValidator(&g.client, p),
 		},
 	}
-	if err := survey.Ask(commonQ, p); err != nil {
+	if err := survey.Ask(commonQ, p, p.AskOpt()); err != nil {
 		log.Fatalf("got questions answers err: %v", err)
 	}
 
@@ -62,7 +55,7 @@ func (g *tokenKubeconfig) ParseParams(p *generate.Params) {
 				Validate: survey.Required,
 			},
 		}
-		if err := survey.Ask(inputSAQ, p); err != nil {
+		if err := survey.Ask(inputSAQ, p, p.AskOpt()); err != nil {
 			log.Fatalf("got questions answers err: %v", err)
 		}
 	} else {
@@ -76,7 +69,7 @@ func (g *tokenKubeconfig) ParseParams(p *generate.Params) {
 				},
 			},
 		}
-		if err := survey.Ask(selectSAQ, p); err != nil {
+		if err := survey.Ask(selectSAQ, p, p.AskOpt()); err != nil {
 			log.Fatalf("got questions answers err: %v", err)
 		}
 	}
@@ -89,48 +82,19 @@ func (g *tokenKubeconfig) ParseParams(p *generate.Params) {
 			},
 		},
 	}
-	if err := survey.Ask(saveAsQ, p); err != nil {
+	if err := survey.Ask(generate.OmitSaveAs(saveAsQ, *p), p, p.AskOpt()); err != nil {
 		log.Fatalf("got questions answers err: %v", err)
 	}
 
-	if p.Scope == generate.ClusterScope {
-		var scopeQ = []*survey.Question{
-			{
-				Name: "clusterRoles",
-				Prompt: &survey.MultiSelect{
-					Message: "Please choose some cluster roles:",
-					Options: clusterRoleNames,
-				},
-			},
-		}
-		if err := survey.Ask(scopeQ, p); err != nil {
-			log.Fatalf("got questions answers err: %v", err)
-		}
-	} else {
-		var scopeQ = []*survey.Question{
-			{
-				Name: "namespaces",
-				Prompt: &survey.Input{
-					Message: "Please input namespaces you want to generate kubeconfig for, split by ',':",
-				},
-				Validate: survey.Required,
-			},
-			{
-				Name: "clusterRoles",
-				Prompt: &survey.MultiSelect{
-					Message: "Please choose some cluster roles:",
-					Options: clusterRoleNames,
-				},
-			},
-		}
-		if err := survey.Ask(scopeQ, p); err != nil {
-			log.Fatalf("got questions answers err: %v", err)
-		}
-	}
+	generate.AskScope(&g.client, p)
 }
 
 func (g *tokenKubeconfig) PreGenerate(p *generate.Params) {
 	if !p.ExistedSA {
+		if err := g.client.EnsureNamespace(p.ServiceAccountNamespace, *p); err != nil {
+			log.Fatalf("%v", err)
+		}
+
 		accountNames := g.client.GetServiceAccountNames(p.ServiceAccountNamespace)
 		for _, name := range accountNames {
 			if name == p.Username {
@@ -143,25 +107,24 @@ func (g *tokenKubeconfig) PreGenerate(p *generate.Params) {
 			},
 		}
 
-		if _, err := g.clientSet.CoreV1().ServiceAccounts(p.ServiceAccountNamespace).Create(sa); err != nil {
+		if _, err := g.clientSet.CoreV1().ServiceAccounts(p.ServiceAccountNamespace).Create(context.TODO(), sa, metav1.CreateOptions{}); err != nil {
 			log.Fatalf("service account create err: %v", err)
 		}
 	}
 
-	sa, err := g.clientSet.CoreV1().ServiceAccounts(p.ServiceAccountNamespace).Get(p.Username, metav1.GetOptions{})
+	token, err := g.client.GetServiceAccountToken(p.ServiceAccountNamespace, p.Username, p.Lifetime)
 	if err != nil {
-		log.Fatalf("got service account err: %v", err)
+		log.Fatalf("got service account token err: %v", err)
 	}
 
-	secret, err := g.clientSet.CoreV1().Secrets(p.ServiceAccountNamespace).Get(sa.Secrets[0].Name, metav1.GetOptions{})
-	if err != nil {
-		log.Fatalf("got service account err: %v", err)
-	}
-
-	p.Token = string(secret.Data["token"])
+	p.Token = token
 }
 func (g *tokenKubeconfig) PostGenerate(p *generate.Params) {
-	if err := g.client.GenerateBinding("ServiceAccount", p.ServiceAccountNamespace, p.Username, p.ClusterRoles, p.NamespaceSlice()); err != nil {
-		log.Errorf("generate binding err: %v", err)
+	if err := g.client.ApplyCustomRole(p); err != nil {
+		log.Fatalf("apply custom role err: %v", err)
+	}
+
+	if err := g.client.GenerateBinding("ServiceAccount", *p); err != nil {
+		log.Fatalf("generate binding err: %v", err)
 	}
 }
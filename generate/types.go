@@ -1,13 +1,25 @@
 package generate
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
 	"strings"
+	"text/template"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2/terminal"
+	"github.com/cloudflare/cfssl/log"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 const (
-	TokenType      string = "token"
-	ClientCertType string = "cert"
+	TokenType       string = "token"
+	ClientCertType  string = "cert"
+	ImpersonateType string = "impersonate"
 
 	ClusterScope   string = "cluster"
 	NamespaceScope string = "namespace"
@@ -33,21 +45,311 @@ type Params struct {
 	Scope                   string
 	Namespaces              string
 	ClusterRoles            []string
+	Roles                   []string
+	RoleSpec                string
+	CustomRules             []rbacv1.PolicyRule
 	ExistedSA               bool
 	ServiceAccountNamespace string
+	Groups                  string
+	Gateway                 string
+	Force                   bool
+	BindingNameTemplate     string
+	NamespaceSelector       string
+	NamespaceGlob           string
+	CreateNamespaces        bool
+	NamespaceLabels         string
+	NamespaceAnnotations    string
+	Onboard                 bool
+	QuotaPreset             string
+	LimitPreset             string
+	PodSecurity             string
+	AllowPrivileged         bool
+	Lifetime                time.Duration
+	MaxLifetime             time.Duration
+	AllowReservedIdentity   bool
+	MergeInto               string
+	OnConflict              string
+	SetCurrentContext       bool
+	OutputCluster           string
+	OutputContext           string
+	OutputUser              string
+	FilenameTemplate        string
+	Server                  string
+	TLSServerName           string
+	ProxyURL                string
+	CAFileRef               string
+	Overwrite               bool
+	OverwriteSecret         bool
+	OutputDir               string
+	Output                  string
+	Secret                  string
+	SecretParts             bool
+
+	// Stdout receives the kubeconfig for StdoutOutput and Console the
+	// prompts, tables and diffs, which is stderr then, so nothing mixes into
+	// the kubeconfig. Both are os.Stdout if nil.
+	Stdout  io.Writer
+	Console terminal.FileWriter
 }
 
+// NamespaceSlice splits p.Namespaces by ',', blank and duplicated names are dropped.
 func (p Params) NamespaceSlice() (res []string) {
-	if p.Namespaces == "" {
-		return
+	seen := make(map[string]bool)
+	for _, ns := range strings.Split(p.Namespaces, ",") {
+		ns = strings.TrimSpace(ns)
+		if ns == "" || seen[ns] {
+			continue
+		}
+		seen[ns] = true
+		res = append(res, ns)
+	}
+	return
+}
+
+// ValidateNamespaces checks that every namespace is a valid DNS-1123 label.
+func (p Params) ValidateNamespaces() error {
+	for _, ns := range p.NamespaceSlice() {
+		if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+			return fmt.Errorf("invalid namespace %q: %s", ns, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// ValidateCluster checks the server and proxy urls written to the cluster
+// entry of the generated kubeconfig.
+func (p Params) ValidateCluster() error {
+	if p.Server != "" && p.Server != ServerAuto {
+		u, err := url.Parse(p.Server)
+		if err != nil {
+			return fmt.Errorf("invalid server %q: %w", p.Server, err)
+		}
+		if u.Scheme != "https" && u.Scheme != "http" || u.Host == "" {
+			return fmt.Errorf("invalid server %q: want an url like https://host:port", p.Server)
+		}
 	}
-	return strings.Split(p.Namespaces, ",")
+
+	if p.ProxyURL != "" {
+		u, err := url.Parse(p.ProxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy url %q: %w", p.ProxyURL, err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("invalid proxy url %q: scheme must be http, https or socks5", p.ProxyURL)
+		}
+		if u.Host == "" {
+			return fmt.Errorf("invalid proxy url %q: missing host", p.ProxyURL)
+		}
+	}
+
+	return nil
+}
+
+// ValidateLifetime refuses a negative lifetime. A client certificate can not
+// request a lifetime with the certificates/v1beta1 CSR, the signer issues it
+// with its own duration, so the lifetime is only checked against the issued
+// certificate, see CertLifetime.
+func (p Params) ValidateLifetime() error {
+	if p.Lifetime < 0 {
+		return fmt.Errorf("invalid lifetime %s", p.Lifetime)
+	}
+	if p.Type == ClientCertType && p.CertLifetime() > 0 {
+		log.Warningf("the signer decides the lifetime of the client certificate, it is refused if it outlives %s", p.CertLifetime())
+	}
+	return nil
 }
 
+// CertLifetime is the lifetime an issued client certificate may not outlive:
+// the requested lifetime, or the max lifetime of the policy.
+func (p Params) CertLifetime() time.Duration {
+	if p.Lifetime > 0 {
+		return p.Lifetime
+	}
+	return p.MaxLifetime
+}
+
+// GroupSlice returns the trimmed groups of p, empty and duplicate groups are
+// dropped like NamespaceSlice does.
+func (p Params) GroupSlice() (res []string) {
+	seen := make(map[string]bool)
+	for _, group := range strings.Split(p.Groups, ",") {
+		group = strings.TrimSpace(group)
+		if group == "" || seen[group] {
+			continue
+		}
+		seen[group] = true
+		res = append(res, group)
+	}
+	return
+}
+
+// SaveAsFile returns the kubeconfig file of p, a relative name is placed in
+// p.OutputDir.
 func (p Params) SaveAsFile() string {
 	filename := p.SaveAs
 	if filename == "" {
-		filename = fmt.Sprintf("%s.kubeconfig", p.Username)
+		filename = p.outputName(p.FilenameTemplate, fmt.Sprintf("%s.kubeconfig", p.Username))
+	}
+	if p.OutputDir != "" && !filepath.IsAbs(filename) {
+		filename = filepath.Join(p.OutputDir, filename)
 	}
 	return filename
 }
+
+// NameData is the data of the templates naming the entries of the generated
+// kubeconfig and its file, e.g. {{.Cluster}}-{{.Username}}.
+type NameData struct {
+	Cluster  string
+	Username string
+	Type     string
+	// Namespace is only set for the per-namespace contexts of namespace scope
+	Namespace string
+}
+
+func (p Params) nameData() NameData {
+	return NameData{
+		Cluster:  p.ClusterName,
+		Username: p.Username,
+		Type:     p.Type,
+	}
+}
+
+func renderName(text string, data NameData) (string, error) {
+	tmpl, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	name := strings.TrimSpace(buf.String())
+	if name == "" {
+		return "", fmt.Errorf("template %q renders an empty name", text)
+	}
+	return name, nil
+}
+
+// ValidateNames checks the naming templates of p. It runs before the username
+// is asked, with placeholders rendered for the unknown fields, and again with
+// the answers, so the names of the kubeconfig can not fail to render.
+func (p Params) ValidateNames() error {
+	data := p.nameData()
+	for _, f := range []*string{&data.Cluster, &data.Username, &data.Type} {
+		if *f == "" {
+			*f = "placeholder"
+		}
+	}
+
+	for flag, text := range map[string]string{
+		"output-cluster":    p.OutputCluster,
+		"output-context":    p.OutputContext,
+		"output-user":       p.OutputUser,
+		"filename-template": p.FilenameTemplate,
+	} {
+		if text == "" {
+			continue
+		}
+		if _, err := renderName(text, data); err != nil {
+			return fmt.Errorf("invalid -%s template: %w", flag, err)
+		}
+	}
+
+	if p.OutputContext != "" && p.Scope == NamespaceScope {
+		for _, ns := range p.NamespaceSlice() {
+			data.Namespace = ns
+			if _, err := renderName(p.OutputContext, data); err != nil {
+				return fmt.Errorf("invalid -output-context template for %s namespace: %w", ns, err)
+			}
+		}
+	}
+
+	if p.BindingNameTemplate != "" {
+		namer, err := NewBindingNamer(p.BindingNameTemplate)
+		if err != nil {
+			return fmt.Errorf("invalid -binding-name-template: %w", err)
+		}
+		subject := p.subject()
+		if subject.Name == "" {
+			subject.Name = "placeholder"
+		}
+		if _, err := namer.Name(subject, "ClusterRole", "placeholder"); err != nil {
+			return fmt.Errorf("invalid -binding-name-template: %w", err)
+		}
+	}
+	return nil
+}
+
+// outputName renders the naming template text, fallback is used if text is
+// empty. The templates are checked by ValidateNames beforehand, so a render
+// error is not expected here.
+func (p Params) outputName(text, fallback string) string {
+	if text == "" {
+		return fallback
+	}
+	name, err := renderName(text, p.nameData())
+	if err != nil {
+		log.Errorf("render name template %q err: %v, use %q", text, err, fallback)
+		return fallback
+	}
+	return name
+}
+
+// OutputClusterName is the name of the cluster entry in the generated kubeconfig.
+func (p Params) OutputClusterName() string {
+	return p.outputName(p.OutputCluster, p.ClusterName)
+}
+
+// OutputContextName is the name of the context entry in the generated kubeconfig.
+func (p Params) OutputContextName() string {
+	return p.outputName(p.OutputContext, p.OutputClusterName())
+}
+
+// OutputNamespaceContexts returns the names of the per-namespace contexts of
+// namespace scope keyed by namespace, names are suffixed by the namespace if
+// the context template does not tell them apart.
+func (p Params) OutputNamespaceContexts() map[string]string {
+	namespaces := p.NamespaceSlice()
+	if p.Scope != NamespaceScope || len(namespaces) == 0 {
+		return nil
+	}
+
+	res := make(map[string]string, len(namespaces))
+	seen := make(map[string]bool, len(namespaces))
+	unique := true
+	for _, ns := range namespaces {
+		data := p.nameData()
+		data.Namespace = ns
+
+		name := p.OutputClusterName()
+		if p.OutputContext != "" {
+			n, err := renderName(p.OutputContext, data)
+			if err != nil {
+				log.Errorf("render name template %q err: %v, use %q", p.OutputContext, err, name)
+			} else {
+				name = n
+			}
+		}
+		if seen[name] {
+			unique = false
+		}
+		seen[name] = true
+		res[ns] = name
+	}
+
+	if !unique {
+		for ns, name := range res {
+			res[ns] = fmt.Sprintf("%s-%s", name, ns)
+		}
+	}
+	return res
+}
+
+// OutputUserName is the name of the user entry in the generated kubeconfig.
+func (p Params) OutputUserName() string {
+	return p.outputName(p.OutputUser, p.Username)
+}
@@ -3,6 +3,7 @@ package generate
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func Test_Params(t *testing.T) {
@@ -20,3 +21,146 @@ func Test_Params(t *testing.T) {
 		t.Errorf("not equal")
 	}
 }
+
+func Test_GroupSlice(t *testing.T) {
+	p := Params{}
+
+	if len(p.GroupSlice()) != 0 {
+		t.Errorf("want empty slice but got none empty")
+	}
+
+	p = Params{
+		Groups: "dev,ops",
+	}
+
+	if !reflect.DeepEqual(p.GroupSlice(), []string{"dev", "ops"}) {
+		t.Errorf("not equal")
+	}
+
+	p.Groups = " dev, ops,,dev ,"
+	if got := p.GroupSlice(); !reflect.DeepEqual(got, []string{"dev", "ops"}) {
+		t.Errorf("want trimmed groups without empty and duplicate ones but got %q", got)
+	}
+}
+
+func Test_NamespaceSliceTrim(t *testing.T) {
+	p := Params{
+		Namespaces: " dev, ops ,,dev",
+	}
+
+	if !reflect.DeepEqual(p.NamespaceSlice(), []string{"dev", "ops"}) {
+		t.Errorf("want trimmed and unique namespaces but got %v", p.NamespaceSlice())
+	}
+
+	if err := p.ValidateNamespaces(); err != nil {
+		t.Errorf("want nil but got %v", err)
+	}
+
+	p.Namespaces = "dev,Ops_1"
+	if err := p.ValidateNamespaces(); err == nil {
+		t.Errorf("want err for invalid namespace but got nil")
+	}
+}
+
+func Test_NameTemplates(t *testing.T) {
+	p := Params{
+		ClusterName:      "prod",
+		Username:         "alice",
+		OutputContext:    "{{.Cluster}}-{{.Username}}",
+		OutputUser:       "{{.Cluster}}-{{.Username}}",
+		FilenameTemplate: "{{.Cluster}}/{{.Username}}.kubeconfig",
+	}
+
+	if err := p.ValidateNames(); err != nil {
+		t.Fatal(err)
+	}
+	if p.OutputClusterName() != "prod" || p.OutputContextName() != "prod-alice" || p.OutputUserName() != "prod-alice" {
+		t.Errorf("unexpected names: %s %s %s", p.OutputClusterName(), p.OutputContextName(), p.OutputUserName())
+	}
+	if p.SaveAsFile() != "prod/alice.kubeconfig" {
+		t.Errorf("want prod/alice.kubeconfig but got %s", p.SaveAsFile())
+	}
+
+	p.SaveAs = "alice.yaml"
+	if p.SaveAsFile() != "alice.yaml" {
+		t.Errorf("want save as name but got %s", p.SaveAsFile())
+	}
+
+	p.OutputUser = "{{.User}}"
+	if err := p.ValidateNames(); err == nil {
+		t.Errorf("want err for unknown field but got nil")
+	}
+
+	p.OutputUser = ""
+	p.Scope = NamespaceScope
+	p.Namespaces = "dev,prod"
+	p.OutputContext = `{{if ne .Namespace "dev"}}{{.Namespace}}{{end}}`
+	if err := p.ValidateNames(); err == nil {
+		t.Errorf("want err for the empty context name of dev namespace but got nil")
+	}
+
+	p.OutputContext = ""
+	p.BindingNameTemplate = "{{.Username}}-{{.Role"
+	if err := p.ValidateNames(); err == nil {
+		t.Errorf("want err for the unparsable binding name template but got nil")
+	}
+	p.BindingNameTemplate = "{{.User}}-{{.Role}}"
+	if err := p.ValidateNames(); err == nil {
+		t.Errorf("want err for unknown field of the binding name template but got nil")
+	}
+	p.BindingNameTemplate = "{{.Username}}-{{.Role}}"
+	if err := p.ValidateNames(); err != nil {
+		t.Errorf("want no err but got %v", err)
+	}
+}
+
+func Test_ValidateCluster(t *testing.T) {
+	cases := []struct {
+		p     Params
+		valid bool
+	}{
+		{Params{}, true},
+		{Params{Server: "https://k8s.example.com:6443", ProxyURL: "socks5://localhost:1080"}, true},
+		{Params{ProxyURL: "http://proxy:3128"}, true},
+		{Params{Server: "k8s.example.com:6443"}, false},
+		{Params{Server: "https://"}, false},
+		{Params{ProxyURL: "ftp://proxy"}, false},
+		{Params{ProxyURL: "socks5://"}, false},
+	}
+
+	for _, c := range cases {
+		if err := c.p.ValidateCluster(); (err == nil) != c.valid {
+			t.Errorf("%+v: want valid %v but got err %v", c.p, c.valid, err)
+		}
+	}
+}
+
+func Test_ValidateLifetime(t *testing.T) {
+	cases := []struct {
+		p     Params
+		valid bool
+	}{
+		{Params{Type: ClientCertType}, true},
+		{Params{Type: ClientCertType, Lifetime: time.Hour}, true},
+		{Params{Type: TokenType, Lifetime: time.Hour}, true},
+		{Params{Type: TokenType, Lifetime: -time.Hour}, false},
+	}
+
+	for _, c := range cases {
+		if err := c.p.ValidateLifetime(); (err == nil) != c.valid {
+			t.Errorf("%+v: want valid %v but got err %v", c.p, c.valid, err)
+		}
+	}
+}
+
+func Test_SaveAsFileOutputDir(t *testing.T) {
+	p := Params{Username: "alice", OutputDir: "out"}
+	if got := p.SaveAsFile(); got != "out/alice.kubeconfig" {
+		t.Errorf("want out/alice.kubeconfig but got %s", got)
+	}
+
+	p.SaveAs = "/tmp/alice.kubeconfig"
+	if got := p.SaveAsFile(); got != "/tmp/alice.kubeconfig" {
+		t.Errorf("want the absolute name kept but got %s", got)
+	}
+}
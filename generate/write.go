@@ -0,0 +1,189 @@
+package generate
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2/terminal"
+	kubecmd "k8s.io/client-go/tools/clientcmd"
+)
+
+// StdoutOutput as the output writes the kubeconfig to stdout.
+const StdoutOutput = "-"
+
+// stdout is where the kubeconfig is written for StdoutOutput.
+func (p Params) stdout() io.Writer {
+	if p.Stdout != nil {
+		return p.Stdout
+	}
+	return os.Stdout
+}
+
+// ConsoleOut is where the prompts, tables and diffs are written.
+func (p Params) ConsoleOut() terminal.FileWriter {
+	if p.Console != nil {
+		return p.Console
+	}
+	return os.Stdout
+}
+
+// sensitiveKeys are the kubeconfig fields whose values are redacted in diffs.
+var sensitiveKeys = []string{"client-certificate-data", "client-key-data", "token", "password"}
+
+// CheckOutput fails when the kubeconfig file of p exists and p.Overwrite is
+// not set, or when merging into p.MergeInto conflicts, it runs before anything
+// is created in the cluster. The diff to the kubeconfig rendered so far is
+// written to w, the credentials are not issued yet, so they show up as
+// removed.
+func (p Params) CheckOutput(w io.Writer) error {
+	if p.MergeInto != "" {
+		if err := CheckMerge(p.MergeInto, BuildKubeConfig(p), p.mergeOptions()); err != nil {
+			return fmt.Errorf("merge kubeconfig into %s err: %w", p.MergeInto, err)
+		}
+		return nil
+	}
+	if p.Output == StdoutOutput || p.secretOnly() || p.Overwrite {
+		return nil
+	}
+
+	filename := p.SaveAsFile()
+	old, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := kubecmd.Write(*BuildKubeConfig(p))
+	if err != nil {
+		return fmt.Errorf("serialize kubeconfig err: %w", err)
+	}
+	fmt.Fprint(w, DiffKubeConfig(filename, old, data))
+	return fmt.Errorf("kubeconfig %s already exists, rerun with -overwrite to replace it", filename)
+}
+
+// WriteKubeConfig writes the serialized kubeconfig of the user to w, so it
+// can be piped to another tool without touching the disk.
+func WriteKubeConfig(w io.Writer, input Params) error {
+	data, err := kubecmd.Write(*BuildKubeConfig(input))
+	if err != nil {
+		return fmt.Errorf("serialize kubeconfig err: %w", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// writeKubeConfigFile writes data to filename with mode 0600. The diff of an
+// existing file which is replaced is written to w, CheckOutput refuses to
+// replace it beforehand unless overwrite is asked for.
+func writeKubeConfigFile(w io.Writer, filename string, data []byte) error {
+	old, err := ioutil.ReadFile(filename)
+	switch {
+	case err == nil:
+		if bytes.Equal(old, data) {
+			return os.Chmod(filename, 0600)
+		}
+		fmt.Fprint(w, DiffKubeConfig(filename, old, data))
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	return WriteFileAtomic(filename, data, 0600)
+}
+
+// WriteFileAtomic writes data to a temp file next to filename and renames it,
+// so filename is either the old or the new content, never a partial one.
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
+
+// DiffKubeConfig returns the line diff of two kubeconfigs with the
+// credentials redacted, only a hash of them is shown.
+func DiffKubeConfig(filename string, old, generated []byte) string {
+	a := redactLines(string(old))
+	b := redactLines(string(generated))
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s (generated)\n", filename, filename)
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			fmt.Fprintf(&sb, "  %s\n", a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&sb, "- %s\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(&sb, "+ %s\n", b[j])
+			j++
+		}
+	}
+	return sb.String()
+}
+
+func redactLines(s string) []string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	for i, l := range lines {
+		trimmed := strings.TrimLeft(l, " -")
+		for _, key := range sensitiveKeys {
+			if strings.HasPrefix(trimmed, key+": ") {
+				value := strings.TrimPrefix(trimmed, key+": ")
+				sum := sha256.Sum256([]byte(value))
+				lines[i] = fmt.Sprintf("%s%s: <redacted sha256:%x>", l[:len(l)-len(trimmed)], key, sum[:4])
+				break
+			}
+		}
+	}
+	return lines
+}
@@ -0,0 +1,100 @@
+package generate
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_writeKubeConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "out", "alice.kubeconfig")
+	var diff bytes.Buffer
+	if err := writeKubeConfigFile(&diff, filename, []byte("token: old\n")); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(filename); err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("want file with mode 0600 but got %v, err: %v", fi, err)
+	}
+
+	if err := writeKubeConfigFile(&diff, filename, []byte("token: new\n")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(filename); string(data) != "token: new\n" {
+		t.Errorf("want the file replaced but got %q", data)
+	}
+	if strings.Contains(diff.String(), "old") || !strings.Contains(diff.String(), "- token: <redacted") {
+		t.Errorf("want a redacted diff but got %q", diff.String())
+	}
+
+	files, _ := ioutil.ReadDir(filepath.Dir(filename))
+	if len(files) != 1 {
+		t.Errorf("want no temp file left but got %d files", len(files))
+	}
+}
+
+func Test_CheckOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := Params{ClusterName: "prod", Username: "alice", OutputDir: dir}
+	var diff bytes.Buffer
+	if err := p.CheckOutput(&diff); err != nil || diff.Len() != 0 {
+		t.Fatalf("want no err for a new file but got %v, diff %q", err, diff.String())
+	}
+
+	if err := ioutil.WriteFile(p.SaveAsFile(), []byte("token: old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CheckOutput(&diff); err == nil {
+		t.Errorf("want err for an existing file but got nil")
+	}
+	if strings.Contains(diff.String(), "old") || !strings.Contains(diff.String(), "- token: <redacted") ||
+		!strings.Contains(diff.String(), "+ current-context: prod") {
+		t.Errorf("want a redacted diff to the rendered kubeconfig but got %q", diff.String())
+	}
+
+	p.Overwrite = true
+	if err := p.CheckOutput(&diff); err != nil {
+		t.Errorf("want no err with overwrite but got %v", err)
+	}
+}
+
+func Test_DiffKubeConfig(t *testing.T) {
+	old := "a\nb\nc\n"
+	got := DiffKubeConfig("f", []byte(old), []byte("a\nx\nc\nd\n"))
+	want := "--- f\n+++ f (generated)\n  a\n- b\n+ x\n  c\n+ d\n"
+	if got != want {
+		t.Errorf("want %q but got %q", want, got)
+	}
+}
+
+func Test_WriteKubeConfig(t *testing.T) {
+	p := Params{ClusterName: "prod", Username: "alice", Token: "secret", Output: StdoutOutput}
+
+	var buf bytes.Buffer
+	if err := WriteKubeConfig(&buf, p); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "token: secret") || !strings.Contains(buf.String(), "current-context: prod") {
+		t.Errorf("want the serialized kubeconfig but got %q", buf.String())
+	}
+
+	if err := p.CheckOutput(ioutil.Discard); err != nil {
+		t.Errorf("want no file check for stdout but got %v", err)
+	}
+	if r := NewResult(p); r.Kubeconfig != StdoutOutput {
+		t.Errorf("want result kubeconfig %s but got %s", StdoutOutput, r.Kubeconfig)
+	}
+}
@@ -2,66 +2,248 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/AlecAivazis/survey/v2"
 	"github.com/cloudflare/cfssl/log"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	kubecmd "k8s.io/client-go/tools/clientcmd"
 
 	"github.com/yahaa/gen-kubecfg/generate"
 	"github.com/yahaa/gen-kubecfg/generate/cert"
+	"github.com/yahaa/gen-kubecfg/generate/impersonate"
+	"github.com/yahaa/gen-kubecfg/generate/policy"
 	"github.com/yahaa/gen-kubecfg/generate/token"
 	"github.com/yahaa/gen-kubecfg/utils"
 )
 
 var (
-	caConfigMap = "extension-apiserver-authentication"
-	caFileName  = "client-ca-file"
 	kubeConfig  string
+	roleSpec    string
+	force       bool
+	bindingName string
+	nsSelector  string
+	nsGlob      string
+	createNs    bool
+	nsLabels    string
+	nsAnnos     string
+	onboard     bool
+	quota       string
+	limits      string
+	podSecurity string
+	report      bool
+	resultFile  string
+	privileged  bool
+	policyFile  string
+	lifetime    time.Duration
+	reservedID  bool
+	genType     string
+	noPreflight bool
+	as          string
+	asGroups    stringSlice
+	mergeInto   string
+	onConflict  string
+	setContext  bool
+	kubeContext string
+	kubeCluster string
+	outCluster  string
+	outContext  string
+	outUser     string
+	outFilename string
+	server      string
+	tlsServer   string
+	proxyURL    string
+	caFileRef   string
+	caSource    string
+	caFile      string
+	overwrite   bool
+	outputDir   string
+	output      string
+	secretRef   string
+	secretParts bool
+	secretCfg   string
+	secretCtx   string
+	secretRepl  bool
 	clientSet   *kubernetes.Clientset
 )
 
+// stringSlice is a flag which can be repeated.
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
 func init() {
 	flagSet := flag.CommandLine
 
 	flagSet.StringVar(&kubeConfig, "kubeconfig", path.Join(os.Getenv("HOME"), "/.kube/config"), "kubeconfig name")
+	flagSet.StringVar(&kubeContext, "context", "", "context of the kubeconfig to use instead of current-context")
+	flagSet.StringVar(&kubeCluster, "cluster", "", "cluster of the kubeconfig to use instead of the one of the context")
+
+	flagSet.StringVar(&roleSpec, "role-spec", "", "yaml file with rules of a custom role created for the user")
+
+	flagSet.BoolVar(&force, "force", false, "replace existing bindings which refer to another role or belong to another subject")
+
+	flagSet.StringVar(&bindingName, "binding-name-template", generate.DefaultBindingNameTemplate, "go template of the binding names, fields: Kind, Namespace, Username, RoleKind, Role, Hash")
+
+	flagSet.StringVar(&nsSelector, "namespace-selector", "", "label selector of the namespaces for namespace scope, e.g. team=payments")
+	flagSet.StringVar(&nsGlob, "namespace-glob", "", "globs of the namespaces for namespace scope split by ',', e.g. payments-*")
+
+	flagSet.BoolVar(&createNs, "create-namespaces", false, "create the namespaces which do not exist")
+	flagSet.StringVar(&nsLabels, "namespace-labels", "", "labels of created namespaces, e.g. team=payments,env=prod")
+	flagSet.StringVar(&nsAnnos, "namespace-annotations", "", "annotations of created namespaces, e.g. owner=alice")
+
+	flagSet.BoolVar(&onboard, "onboard", false, "onboard tenant namespaces: labels, quota, limit range and default-deny network policy; existing namespaces are only onboarded once confirmed or with -force")
+	flagSet.StringVar(&quota, "quota-preset", "small", "resource quota preset of onboarded namespaces: small, medium or large")
+	flagSet.StringVar(&limits, "limit-preset", "small", "limit range preset of onboarded namespaces: small, medium or large")
+	flagSet.StringVar(&podSecurity, "pod-security", "restricted", "pod security admission level of onboarded namespaces")
+
+	flagSet.BoolVar(&report, "report", true, "report the effective permissions of the generated kubeconfig")
+	flagSet.StringVar(&resultFile, "result", "", "write the result of this run as json to the file")
+
+	flagSet.BoolVar(&privileged, "allow-privileged", false, "grant privileged roles without confirmation")
+
+	flagSet.StringVar(&policyFile, "policy", "", "issuance policy file constraining who can grant what, enforced in addition to "+policy.DefaultFile)
+	flagSet.DurationVar(&lifetime, "lifetime", 0, "lifetime of the issued token, e.g. 720h; a client certificate issued for longer is refused")
+
+	flagSet.BoolVar(&reservedID, "allow-reserved-identity", false, "allow issuing reserved identities like system:* users or the system:masters group")
+
+	flagSet.StringVar(&genType, "type", "", "access type of kubeconfig: token, cert or impersonate")
+	flagSet.BoolVar(&noPreflight, "skip-preflight", false, "skip checking the permissions of the operator")
+
+	flagSet.StringVar(&as, "as", "", "user to impersonate for the whole run")
+	flagSet.Var(&asGroups, "as-group", "group to impersonate for the whole run, can be repeated")
+
+	flagSet.StringVar(&mergeInto, "merge-into", "", "merge the generated cluster, user and context into this kubeconfig file instead of writing a new one")
+	flagSet.StringVar(&onConflict, "on-conflict", generate.ConflictReject, "policy of name collisions when merging: reject, rename or overwrite")
+	flagSet.BoolVar(&setContext, "set-current-context", false, "switch current-context of the merged kubeconfig to the generated one")
+
+	flagSet.StringVar(&outCluster, "output-cluster", "", "go template naming the cluster entry in the generated kubeconfig, e.g. '{{.Cluster}}', fields: Cluster, Username, Type")
+	flagSet.StringVar(&outContext, "output-context", "", "go template naming the context entry in the generated kubeconfig, e.g. '{{.Cluster}}-{{.Namespace}}', Namespace is set for the per-namespace contexts of namespace scope (default the cluster entry name)")
+	flagSet.StringVar(&outUser, "output-user", "", "go template naming the user entry in the generated kubeconfig, e.g. '{{.Cluster}}-{{.Username}}' (default the username)")
+	flagSet.StringVar(&outFilename, "filename-template", "", "go template naming the kubeconfig file when save as name is empty, e.g. '{{.Cluster}}-{{.Username}}.kubeconfig'")
+
+	flagSet.StringVar(&server, "server", "", "server url of the generated kubeconfig, e.g. https://k8s.example.com:6443, or auto to pick the preferred discovered endpoint (default ask among the discovered endpoints)")
+	flagSet.StringVar(&tlsServer, "tls-server-name", "", "server name used to verify the serving certificate of the server in the generated kubeconfig")
+	flagSet.StringVar(&proxyURL, "proxy-url", "", "http, https or socks5 proxy of the server in the generated kubeconfig, e.g. socks5://localhost:1080")
+	flagSet.StringVar(&caFileRef, "ca-file-ref", "", "CA file path referenced by the generated kubeconfig instead of embedding the CA data, it must exist on the machine of the user")
+
+	flagSet.StringVar(&caSource, "ca-source", generate.CASourceAuto, "source of the cluster CA: auto, kubeconfig, root-ca, cluster-info, file or handshake, auto takes the first of kubeconfig, root-ca and cluster-info verifying the server, handshake asks to trust the top certificate the server presents")
+	flagSet.StringVar(&caFile, "ca-file", "", "CA bundle file of the cluster, read by the file and auto CA sources")
+
+	flagSet.BoolVar(&overwrite, "overwrite", false, "replace an existing kubeconfig file, the diff of the change is shown")
+	flagSet.StringVar(&outputDir, "output-dir", "", "directory of the generated kubeconfig file, it is created if missing")
+
+	flagSet.StringVar(&output, "output", "", "kubeconfig file to write instead of asking the save as name, - writes it to stdout")
+
+	flagSet.StringVar(&secretRef, "secret", "", "namespace/name of a secret to save the kubeconfig in, no file is written unless -output is given too")
+	flagSet.BoolVar(&secretParts, "secret-parts", false, "also save the CA, client certificate, key and token under their own keys of the secret")
+	flagSet.StringVar(&secretCfg, "secret-kubeconfig", "", "kubeconfig of the cluster to save the secret in (default the cluster of -kubeconfig)")
+	flagSet.StringVar(&secretCtx, "secret-context", "", "context of the cluster to save the secret in")
+	flagSet.BoolVar(&secretRepl, "overwrite-secret", false, "replace a -secret which is not generated for the subject")
 
 	flagSet.Parse(os.Args[1:])
 }
 
 func main() {
-	cfg, err := utils.NewClusterConfig(kubeConfig)
+	if output != "" && mergeInto != "" {
+		log.Fatalf("-output and -merge-into can not be used together")
+	}
+
+	// the default policy holds no matter which admin runs gen-kubecfg
+	var pols []*policy.Policy
+	if pol, err := policy.LoadDefault(); err != nil {
+		log.Fatalf("load policy %s err: %v", policy.DefaultFile, err)
+	} else if pol != nil {
+		pols = append(pols, pol)
+	}
+	if policyFile != "" {
+		pol, err := policy.Load(policyFile)
+		if err != nil {
+			log.Fatalf("load policy err: %v", err)
+		}
+		pols = append(pols, pol)
+	}
+
+	opts := utils.Options{
+		Context:  kubeContext,
+		Cluster:  kubeCluster,
+		As:       as,
+		AsGroups: asGroups,
+	}
+
+	cfg, err := utils.NewClusterConfig(kubeConfig, opts)
 	if err != nil {
 		log.Fatalf("create cluster config err: %v", err)
 	}
-	clientSet, err = utils.NewClientset(kubeConfig)
+	clientSet, err = utils.NewClientset(kubeConfig, opts)
 	if err != nil {
 		log.Fatalf("create k8s client err: %v", err)
 	}
 
-	clusterName, err := generate.GetClusterName(kubeConfig)
+	clusterName, err := generate.GetClusterName(kubeConfig, kubeContext, kubeCluster)
 	if err != nil {
 		log.Fatalf("get cluster name from kubeConfig err: %v", err)
 	}
 
-	cm, err := clientSet.CoreV1().ConfigMaps("kube-system").Get(caConfigMap, metav1.GetOptions{})
-	if err != nil {
-		log.Fatalf("get config map %s err %w", caConfigMap, err)
-	}
-
-	ca, ok := cm.Data[caFileName]
-	if !ok {
-		log.Fatalf("not found %s", caFileName)
-	}
-
 	client := generate.NewClient(clientSet)
 
 	params := generate.Params{
-		ClusterEndpoint: cfg.Host,
-		ClusterName:     clusterName,
-		ClusterCA:       ca,
+		ClusterEndpoint:       cfg.Host,
+		ClusterName:           clusterName,
+		Type:                  genType,
+		RoleSpec:              roleSpec,
+		Force:                 force,
+		BindingNameTemplate:   bindingName,
+		NamespaceSelector:     nsSelector,
+		NamespaceGlob:         nsGlob,
+		CreateNamespaces:      createNs,
+		NamespaceLabels:       nsLabels,
+		NamespaceAnnotations:  nsAnnos,
+		Onboard:               onboard,
+		QuotaPreset:           quota,
+		LimitPreset:           limits,
+		PodSecurity:           podSecurity,
+		AllowPrivileged:       privileged,
+		Lifetime:              lifetime,
+		AllowReservedIdentity: reservedID,
+		MergeInto:             mergeInto,
+		OnConflict:            onConflict,
+		SetCurrentContext:     setContext,
+		OutputCluster:         outCluster,
+		OutputContext:         outContext,
+		OutputUser:            outUser,
+		FilenameTemplate:      outFilename,
+		Server:                server,
+		TLSServerName:         tlsServer,
+		ProxyURL:              proxyURL,
+		CAFileRef:             caFileRef,
+		Overwrite:             overwrite,
+		OverwriteSecret:       secretRepl,
+		OutputDir:             outputDir,
+		Output:                output,
+		Secret:                secretRef,
+		SecretParts:           secretParts,
+		Stdout:                os.Stdout,
+		Console:               os.Stdout,
+	}
+	if output != generate.StdoutOutput {
+		params.SaveAs = output
+	} else {
+		// only the kubeconfig goes to stdout, the prompts and reports go to stderr
+		params.Console = os.Stderr
 	}
 
 	var typeQ = []*survey.Question{
@@ -69,14 +251,51 @@ func main() {
 			Name: "type",
 			Prompt: &survey.Select{
 				Message: "Please choose an access type of kubeconfig:",
-				Options: []string{generate.TokenType, generate.ClientCertType},
+				Options: []string{generate.TokenType, generate.ClientCertType, generate.ImpersonateType},
 				Default: generate.TokenType,
 			},
 		},
 	}
 
-	if err := survey.Ask(typeQ, &params); err != nil {
-		log.Fatalf("got questions answers err: %v", err)
+	if err := params.ValidateNames(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if err := params.ValidateCluster(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if params.Secret != "" {
+		if _, _, err := params.SecretNamespaceName(); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	if params.Type == "" {
+		if err := survey.Ask(typeQ, &params, params.AskOpt()); err != nil {
+			log.Fatalf("got questions answers err: %v", err)
+		}
+	}
+
+	if !noPreflight {
+		if !generate.PrintPreflight(params.Console, client.Preflight(generate.TypeChecks(params, caSource))) {
+			log.Fatalf("preflight checks failed, nothing is created")
+		}
+	}
+
+	// the CA is not embedded when the kubeconfig refers to a CA file
+	if params.CAFileRef == "" {
+		ca, err := client.DiscoverCA(caSource, cfg, caFile, params.Console)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		params.ClusterCA = ca
+	}
+
+	generate.AskServer(client, &params)
+
+	if err := params.VerifyServer(); err != nil {
+		log.Fatalf("%v", err)
 	}
 
 	var g generate.Generator
@@ -85,12 +304,154 @@ func main() {
 		g = cert.New(*client)
 	case generate.TokenType:
 		g = token.New(*client)
+	case generate.ImpersonateType:
+		g = impersonate.New(*client)
 	default:
 		log.Fatalf("not support type: %v", params.Type)
 	}
 
+	if params.Onboard {
+		if err := params.ValidateOnboard(); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
 	g.ParseParams(&params)
+
+	if err := params.ValidateNames(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if params.Onboard {
+		if err := client.CheckOnboard(params); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	if err := params.CheckOutput(params.Console); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	secretTarget := client
+	if params.Secret != "" {
+		if secretCfg != "" || secretCtx != "" {
+			// the secret may be in another cluster, where the service account
+			// can not be its owner
+			if secretCfg == "" {
+				secretCfg = kubeConfig
+			}
+			// the secret is saved as the operator, impersonated like the client
+			cs, err := utils.NewClientset(secretCfg, utils.Options{Context: secretCtx, As: as, AsGroups: asGroups})
+			if err != nil {
+				log.Fatalf("new clientset of secret cluster err: %v", err)
+			}
+			secretTarget = generate.NewClient(cs)
+		}
+		if err := secretTarget.CheckKubeConfigSecret(params); err != nil {
+			log.Fatalf("check kubeconfig secret err: %v", err)
+		}
+	}
+
+	for _, pol := range pols {
+		if err := pol.Check(&params); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}
+
+	if err := params.ValidateLifetime(); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if !noPreflight {
+		bindChecks, err := client.BindChecks(params)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		checks := append(generate.PreflightChecks(params), bindChecks...)
+		if !generate.PrintPreflight(params.Console, client.Preflight(checks)) {
+			log.Fatalf("preflight checks failed, nothing is created")
+		}
+	}
+
+	if err := client.CheckBindings(params); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if params.Onboard {
+		if err := client.Onboard(params); err != nil {
+			log.Fatalf("onboard namespaces err: %v", err)
+		}
+	}
+
 	g.PreGenerate(&params)
 	g.Generate(&params)
 	g.PostGenerate(&params)
+
+	if params.Secret != "" {
+		secret, err := generate.BuildKubeConfigSecret(params)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+
+		// the service account can not own a secret in another cluster
+		if secretTarget == client {
+			if sa, err := client.OwnerServiceAccount(params); err != nil {
+				log.Warningf("get owner service account of secret err: %v", err)
+			} else if sa != nil && !generate.SetServiceAccountOwner(secret, sa) {
+				log.Warningf("service account %s/%s is not in the namespace of the secret, it is not set as owner", sa.Namespace, sa.Name)
+			}
+		}
+
+		if err := secretTarget.ApplyKubeConfigSecret(secret, params); err != nil {
+			log.Fatalf("save kubeconfig as secret err: %v", err)
+		}
+	}
+
+	result := generate.NewResult(params)
+
+	if report {
+		// the credentials are issued already, a report which can not be built
+		// does not fail the run
+		if r, err := permissionReport(client, cfg, params); err != nil {
+			log.Warningf("the permission report is skipped: %v", err)
+		} else {
+			r.Print(params.Console, params.Username)
+			result.Permissions = r
+		}
+	}
+
+	if resultFile != "" {
+		if err := result.WriteFile(resultFile); err != nil {
+			log.Fatalf("write result err: %v", err)
+		}
+	}
+}
+
+// permissionReport reviews the permissions of the generated kubeconfig. It is
+// reviewed from here, so it reaches the server the same way as the
+// -kubeconfig of the operator.
+func permissionReport(client *generate.Client, cfg *rest.Config, params generate.Params) (*generate.PermissionReport, error) {
+	reportParams := params
+	reportParams.Server, reportParams.TLSServerName = "", ""
+	reportParams.ProxyURL, reportParams.CAFileRef = "", ""
+	if params.CAFileRef != "" {
+		// the referenced file is on the machine of the user, it is used if
+		// it is here too, the CA is discovered otherwise
+		if ca, err := ioutil.ReadFile(params.CAFileRef); err == nil {
+			reportParams.ClusterCA = string(ca)
+		} else if reportParams.ClusterCA, err = client.DiscoverCA(caSource, cfg, caFile, params.Console); err != nil {
+			return nil, fmt.Errorf("discover cluster CA of the report err: %w", err)
+		}
+	}
+
+	data, err := kubecmd.Write(*generate.BuildKubeConfig(reportParams))
+	if err != nil {
+		return nil, fmt.Errorf("serialize generated kubeconfig err: %w", err)
+	}
+
+	r, err := generate.BuildPermissionReport(data, params.NamespaceSlice())
+	if err != nil {
+		return nil, fmt.Errorf("build permission report err: %w", err)
+	}
+	return r, nil
 }
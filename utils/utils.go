@@ -7,12 +7,25 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
 )
 
 // KubeConfigEnv (optionally) specify the location of kubeconfig file
 const KubeConfigEnv = "KUBECONFIG"
 
-func NewClusterConfig(kubeconfig string) (*rest.Config, error) {
+// Options are the options of the cluster config.
+type Options struct {
+	// Context is the context of the kubeconfig to use instead of current-context
+	Context string
+	// Cluster is the cluster of the kubeconfig to use instead of the one of the context
+	Cluster string
+	// As is the user to impersonate, the whole run happens as this identity
+	As string
+	// AsGroups are the groups to impersonate
+	AsGroups []string
+}
+
+func NewClusterConfig(kubeconfig string, opts Options) (*rest.Config, error) {
 	var (
 		cfg *rest.Config
 		err error
@@ -23,7 +36,12 @@ func NewClusterConfig(kubeconfig string) (*rest.Config, error) {
 	}
 
 	if kubeconfig != "" {
-		cfg, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+		loader := &clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfig}
+		overrides := &clientcmd.ConfigOverrides{
+			CurrentContext: opts.Context,
+			Context:        api.Context{Cluster: opts.Cluster},
+		}
+		cfg, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loader, overrides).ClientConfig()
 		if err != nil {
 			return nil, fmt.Errorf("Error creating config from specified file: %s %v\n", kubeconfig, err)
 		}
@@ -33,14 +51,22 @@ func NewClusterConfig(kubeconfig string) (*rest.Config, error) {
 		}
 	}
 
+	if len(opts.AsGroups) > 0 && opts.As == "" {
+		return nil, fmt.Errorf("impersonating groups requires a user to impersonate")
+	}
+	cfg.Impersonate = rest.ImpersonationConfig{
+		UserName: opts.As,
+		Groups:   opts.AsGroups,
+	}
+
 	cfg.QPS = 100
 	cfg.Burst = 100
 
 	return cfg, nil
 }
 
-func NewClientset(kubeconfig string) (*kubernetes.Clientset, error) {
-	cfg, err := NewClusterConfig(kubeconfig)
+func NewClientset(kubeconfig string, opts Options) (*kubernetes.Clientset, error) {
+	cfg, err := NewClusterConfig(kubeconfig, opts)
 	if err != nil {
 		return nil, err
 	}